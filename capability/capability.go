@@ -1,5 +1,7 @@
 package capability
 
+import "strings"
+
 type SupportedSet map[string]struct{}
 
 func (s SupportedSet) IsSupportedMethod(method string) bool {
@@ -12,6 +14,28 @@ func (s SupportedSet) IsSupportedMethod(method string) bool {
 	return supported
 }
 
+// Lookup returns the value of dot notated capability.
+func Lookup(kvCaps map[string]any, dotted string) (any, bool) {
+	var v any = kvCaps
+	for k := range strings.SplitSeq(dotted, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// IsEnabled returns whether the dot notated boolean capability is true.
+func IsEnabled(kvCaps map[string]any, dotted string) bool {
+	v, _ := Lookup(kvCaps, dotted)
+	enabled, _ := v.(bool)
+	return enabled
+}
+
 // CollectSupported returns a map of dot notated capability to whether it's supported or not.
 func CollectSupported(kvCaps map[string]any) SupportedSet {
 	res := map[string]struct{}{}
@@ -63,3 +63,41 @@ func TestSupportedSet_IsSupportedMethod(t *testing.T) {
 		})
 	}
 }
+
+func TestLookup(t *testing.T) {
+	kvCaps := map[string]any{
+		"textDocument": map[string]any{
+			"definition": map[string]any{"linkSupport": true},
+			"hover":      map[string]any{"contentFormat": []any{"markdown", "plaintext"}},
+		},
+	}
+
+	tests := []struct {
+		dotted string
+		want   any
+		found  bool
+	}{
+		{dotted: "textDocument.definition.linkSupport", want: true, found: true},
+		{dotted: "textDocument.hover.contentFormat", want: []any{"markdown", "plaintext"}, found: true},
+		{dotted: "textDocument.declaration.linkSupport", want: nil, found: false},
+		{dotted: "textDocument.definition.linkSupport.foo", want: nil, found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dotted, func(t *testing.T) {
+			got, found := Lookup(kvCaps, tt.dotted)
+			if found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Lookup() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if !IsEnabled(kvCaps, "textDocument.definition.linkSupport") {
+		t.Errorf("IsEnabled() = false, want true")
+	}
+	if IsEnabled(kvCaps, "textDocument.hover.contentFormat") {
+		t.Errorf("IsEnabled() = true for non boolean capability, want false")
+	}
+}
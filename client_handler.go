@@ -8,6 +8,8 @@ import (
 	"log/slog"
 	"maps"
 	"slices"
+	"sync"
+	"time"
 
 	"dario.cat/mergo"
 	"github.com/buzztaiki/lsmux/capability"
@@ -16,19 +18,53 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// DocumentsMethod is an lsmux specific request that returns the documents opened by the client.
+const DocumentsMethod = "lsmux/documents"
+
 type ClientHandler struct {
-	serverRegistry *ServerConnectionRegistry
-	shutdown       bool
-	done           chan struct{}
+	serverRegistry     *ServerConnectionRegistry
+	documents          *DocumentStore
+	diagRegistry       *DiagnosticRegistry
+	diagReports        *DiagnosticReportCache
+	partialResults     *PartialResultRouter
+	cfg                *Config
+	clientCapabilities map[string]any
+	shutdown           bool
+	done               chan struct{}
+
+	clientConn  *jsonrpc2.Connection
+	lazyServers map[string]*ServerSupervisor
+
+	// syncMu serializes document notifications with restoring documents to servers
+	syncMu sync.Mutex
+
+	mu                 sync.Mutex
+	initParams         json.RawMessage
+	serverCapabilities map[string]any // capabilities advertised to the client
+	pullTimers         map[protocol.DocumentUri]*time.Timer
 }
 
-func NewClientHandler(serverRegistry *ServerConnectionRegistry) *ClientHandler {
+// NewClientHandler creates a handler for requests from the client.
+// partialResults should be used as a middleware of all server connections, and diagRegistry should be shared with server handlers.
+func NewClientHandler(serverRegistry *ServerConnectionRegistry, documents *DocumentStore, diagRegistry *DiagnosticRegistry, partialResults *PartialResultRouter, cfg *Config) *ClientHandler {
 	return &ClientHandler{
 		serverRegistry: serverRegistry,
+		documents:      documents,
+		diagRegistry:   diagRegistry,
+		partialResults: partialResults,
+		diagReports:    diagRegistry.Reports(),
+		cfg:            cfg,
 		done:           make(chan struct{}),
+		lazyServers:    make(map[string]*ServerSupervisor),
+		pullTimers:     make(map[protocol.DocumentUri]*time.Timer),
 	}
 }
 
+// SetClientConn sets the connection to the client, which is used to send requests from lsmux itself.
+func (h *ClientHandler) SetClientConn(conn *jsonrpc2.Connection) {
+	h.clientConn = conn
+}
+
 func (h *ClientHandler) WaitExit() {
 	<-h.done
 }
@@ -42,14 +78,46 @@ func (h *ClientHandler) Handle(ctx context.Context, r *jsonrpc2.Request) (any, e
 		return nil, ErrInvalidRequest
 	}
 
-	servers := h.serverRegistry.Servers().FilterBySupportedMethod(r.Method)
-	if len(servers) == 0 {
+	if r.Method == DocumentsMethod {
+		return h.handleDocumentsRequest(ctx, r)
+	}
+
+	// lookup the document before tracking, since didClose removes it
+	doc, docScoped := h.documentOf(r)
+
+	// start lazy servers before broadcasting didOpen, so that they also receive it
+	if docScoped && protocol.MethodKind(r.Method) == protocol.TextDocumentDidOpenMethod {
+		h.startLazyServers(ctx, doc)
+	}
+
+	// track documents even if no server receives them
+	if !r.IsCall() {
+		// servers being restored receive the documents after tracking, or the notification
+		h.syncMu.Lock()
+		defer h.syncMu.Unlock()
+		h.trackDocument(ctx, r)
+	}
+	if docScoped && !r.IsCall() {
+		h.scheduleDiagnosticPull(ctx, r.Method, doc)
+	}
+
+	servers := h.serverRegistry.Servers().FilterByReady().FilterBySupportedMethod(r.Method)
+	if docScoped {
+		servers = servers.FilterByDocument(r.Method, doc)
+	}
+	// push diagnostics are answered even if no server supports pull diagnostics
+	if h.cfg.Diagnostics.Mode == DiagnosticModePull && protocol.MethodKind(r.Method) == protocol.TextDocumentDiagnosticMethod {
+		return h.handleDocumentDiagnosticRequest(ctx, r, servers)
+	}
+	// all servers may be lazy on initialize
+	if len(servers) == 0 && protocol.MethodKind(r.Method) != protocol.InitializeMethod {
 		return nil, ErrMethodNotFound
 	}
 
 	if !r.IsCall() {
+		params := h.serverNotificationParams(r)
 		for _, server := range servers {
-			if err := server.Notify(ctx, r.Method, r.Params); err != nil {
+			if err := server.Notify(ctx, r.Method, params); err != nil {
 				return nil, err
 			}
 		}
@@ -61,65 +129,294 @@ func (h *ClientHandler) Handle(ctx context.Context, r *jsonrpc2.Request) (any, e
 		return h.handleInitializeRequest(ctx, r, servers)
 	case protocol.WorkspaceExecuteCommandMethod:
 		return h.handleExecuteCommandRequest(ctx, r, servers)
-	case protocol.TextDocumentCompletionMethod:
-		return h.handleCompletionRequest(ctx, r, servers)
-	case protocol.TextDocumentCodeActionMethod:
-		return h.handleCodeActionRequest(ctx, r, servers)
 	case protocol.CodeActionResolveMethod:
 		return h.handleCodeActionResolveRequest(ctx, r, servers)
+	case protocol.CompletionItemResolveMethod:
+		return h.handleCompletionItemResolveRequest(ctx, r, servers)
+	case protocol.WorkspaceSymbolResolveMethod:
+		return h.handleWorkspaceSymbolResolveRequest(ctx, r, servers)
+	case protocol.CodeLensResolveMethod:
+		return h.handleCodeLensResolveRequest(ctx, r, servers)
+	case protocol.InlayHintResolveMethod:
+		return h.handleInlayHintResolveRequest(ctx, r, servers)
+	case protocol.TextDocumentFormattingMethod,
+		protocol.TextDocumentRangeFormattingMethod,
+		protocol.TextDocumentRangesFormattingMethod,
+		protocol.TextDocumentOnTypeFormattingMethod:
+		return h.handleFormattingRequest(ctx, r, servers)
 	case protocol.ShutdownMethod:
 		return h.handleShutdownRequest(ctx, r, servers)
+	default:
+		return h.handleRoutedRequest(ctx, r, servers)
+	}
+}
+
+// documentOf returns the document of textDocument in the request params.
+// It returns false if the request is not scoped to a document.
+func (h *ClientHandler) documentOf(r *jsonrpc2.Request) (Document, bool) {
+	var params struct {
+		TextDocument struct {
+			Uri        protocol.DocumentUri  `json:"uri"`
+			LanguageId protocol.LanguageKind `json:"languageId"`
+		} `json:"textDocument"`
+	}
+	if err := json.Unmarshal(r.Params, &params); err != nil || params.TextDocument.Uri == "" {
+		return Document{}, false
+	}
+
+	if doc, found := h.documents.Get(params.TextDocument.Uri); found {
+		return doc, true
+	}
+	// didOpen, or a document not opened by the client
+	return Document{URI: params.TextDocument.Uri, LanguageID: params.TextDocument.LanguageId}, true
+}
+
+// trackDocument updates the document store by text document notifications.
+func (h *ClientHandler) trackDocument(ctx context.Context, r *jsonrpc2.Request) {
+	var err error
+	switch protocol.MethodKind(r.Method) {
+	case protocol.TextDocumentDidOpenMethod:
+		var params protocol.DidOpenTextDocumentParams
+		if err = json.Unmarshal(r.Params, &params); err == nil {
+			h.documents.Open(params.TextDocument)
+			h.diagRegistry.ResetVersion(params.TextDocument.Uri)
+		}
+	case protocol.TextDocumentDidChangeMethod:
+		var params protocol.DidChangeTextDocumentParams
+		if err = json.Unmarshal(r.Params, &params); err == nil {
+			err = h.documents.Change(params)
+		}
+	case protocol.TextDocumentDidCloseMethod:
+		var params protocol.DidCloseTextDocumentParams
+		if err = json.Unmarshal(r.Params, &params); err == nil {
+			h.documents.Close(params.TextDocument.Uri)
+			h.diagReports.Remove(params.TextDocument.Uri)
+			h.diagRegistry.ResetVersion(params.TextDocument.Uri)
+			if h.cfg.Diagnostics.ClearOnClose {
+				h.diagRegistry.Clear(params.TextDocument.Uri)
+				h.diagRegistry.Publish(ctx, params.TextDocument.Uri)
+			}
+		}
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "failed to track document", "error", err)
+	}
+}
+
+// serverNotificationParams returns params of the notification sent to servers.
+// The version of didChange is converted to the version of servers.
+func (h *ClientHandler) serverNotificationParams(r *jsonrpc2.Request) any {
+	if protocol.MethodKind(r.Method) != protocol.TextDocumentDidChangeMethod {
+		return r.Params
+	}
+
+	var kvParams map[string]any
+	if err := json.Unmarshal(r.Params, &kvParams); err != nil {
+		return r.Params
+	}
+	textDocument, ok := kvParams["textDocument"].(map[string]any)
+	if !ok {
+		return r.Params
+	}
+	doc, found := h.documents.Get(textDocumentURI(r.Params))
+	if !found || doc.VersionOffset == 0 {
+		return r.Params
+	}
+	textDocument["version"] = doc.ServerVersion()
+	return kvParams
+}
 
+// handleDocumentsRequest returns the documents opened by the client for debugging.
+func (h *ClientHandler) handleDocumentsRequest(_ context.Context, _ *jsonrpc2.Request) (any, error) {
+	docs := []DocumentSummary{}
+	for _, doc := range h.documents.List() {
+		docs = append(docs, doc.Summary())
+	}
+	return docs, nil
+}
+
+func (h *ClientHandler) handleRoutedRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	strategy := lookupRouting(h.cfg.Routing, r.Method)
+	servers = strategy.Select(servers)
+	if len(servers) == 0 {
+		return nil, ErrMethodNotFound
+	}
+
+	switch {
+	case strategy.Kind() == RoutingAllMerge:
+		return h.handleMergedRequest(ctx, r, servers)
+	case strategy.Kind() == RoutingFirstNonEmpty && isResolvableMethod(r.Method):
+		return h.handleFirstNonEmptyMergedRequest(ctx, r, servers)
+	case strategy.Kind() == RoutingFirstNonEmpty:
+		return h.handleFirstNonEmptyRequest(ctx, r, servers)
+	case isResolvableMethod(r.Method):
+		// items are tagged with the server for future resolve, and diagnostics of code actions are untagged
+		return h.handleMergedRequest(ctx, r, servers[:1])
 	default:
-		// Currently, request is sent to the first server only
 		return servers[0].CallWithRawResult(ctx, r.Method, r.Params)
 	}
 }
 
-func (h *ClientHandler) handleInitializeRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
-	merged := map[string]any{}
-	for _, server := range servers {
-		var kvParams map[string]any
-		if err := json.Unmarshal(r.Params, &kvParams); err != nil {
-			return nil, err
-		}
+func (h *ClientHandler) handleMergedRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	switch protocol.MethodKind(r.Method) {
+	case protocol.TextDocumentCompletionMethod:
+		return h.handleCompletionRequest(ctx, r, servers)
+	case protocol.TextDocumentCodeActionMethod:
+		return h.handleCodeActionRequest(ctx, r, servers)
+	case protocol.TextDocumentDefinitionMethod,
+		protocol.TextDocumentDeclarationMethod,
+		protocol.TextDocumentTypeDefinitionMethod,
+		protocol.TextDocumentImplementationMethod:
+		return h.handleDefinitionRequest(ctx, r, servers)
+	case protocol.TextDocumentReferencesMethod:
+		return h.handleReferencesRequest(ctx, r, servers)
+	case protocol.TextDocumentHoverMethod:
+		return h.handleHoverRequest(ctx, r, servers)
+	case protocol.TextDocumentDocumentSymbolMethod:
+		return h.handleDocumentSymbolRequest(ctx, r, servers)
+	case protocol.WorkspaceSymbolMethod:
+		return h.handleWorkspaceSymbolRequest(ctx, r, servers)
+	case protocol.TextDocumentCodeLensMethod:
+		return h.handleCodeLensRequest(ctx, r, servers)
+	case protocol.TextDocumentInlayHintMethod:
+		return h.handleInlayHintRequest(ctx, r, servers)
+	case protocol.TextDocumentDiagnosticMethod:
+		return h.handleDocumentDiagnosticRequest(ctx, r, servers)
+	case protocol.WorkspaceDiagnosticMethod:
+		return h.handleWorkspaceDiagnosticRequest(ctx, r, servers)
+	default:
+		return h.handleGenericMergedRequest(ctx, r, servers)
+	}
+}
 
-		// override initializationOptions if configured
-		if len(server.InitOptions) != 0 {
-			slog.DebugContext(ctx, "override initializationOptions", "server", server.Name, "initOptions", server.InitOptions)
-			kvParams["initializationOptions"] = server.InitOptions
-		}
+// handleGenericMergedRequest concatenates array results, or returns the first non-empty result if any result is not an array.
+func (h *ClientHandler) handleGenericMergedRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]json.RawMessage, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
 
-		var rawRes json.RawMessage
-		if err := server.Call(ctx, r.Method, kvParams, &rawRes); err != nil {
-			return nil, err
+	var merged []json.RawMessage
+	for _, res := range results {
+		if isEmptyResult(res) {
+			continue
 		}
-
-		var typedRes protocol.InitializeResult
-		if err := json.Unmarshal(rawRes, &typedRes); err != nil {
-			return nil, err
+		var items []json.RawMessage
+		if err := json.Unmarshal(res, &items); err != nil {
+			return firstNonEmptyResult(results), nil
 		}
+		merged = append(merged, items...)
+	}
+	if merged == nil {
+		return firstNonEmptyResult(results), nil
+	}
+	return merged, nil
+}
 
-		var kvRes map[string]any
-		if err := json.Unmarshal(rawRes, &kvRes); err != nil {
-			return nil, err
-		}
+func (h *ClientHandler) handleFirstNonEmptyRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]json.RawMessage, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+	return firstNonEmptyResult(results), nil
+}
+
+// handleFirstNonEmptyMergedRequest is the same as handleFirstNonEmptyRequest,
+// but each result is merged by handleMergedRequest so that items are tagged with the server.
+func (h *ClientHandler) handleFirstNonEmptyMergedRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]any, len(servers))
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = h.handleMergedRequest(ctx, r, ServerConnectionList{server})
+		}()
+	}
+	wg.Wait()
+	// a failed server is treated as an empty result
+	if err := joinServerErrors(ctx, servers, r.Method, errs); err != nil {
+		return nil, err
+	}
+	results = slices.DeleteFunc(results, func(res any) bool { return res == nil })
 
-		kvCaps, ok := kvRes["capabilities"].(map[string]any)
-		if !ok {
-			return nil, errors.New("no capabilities in initialize response")
+	for _, res := range results {
+		if !isEmptyMergedResult(res) {
+			return res, nil
 		}
+	}
+	if len(results) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	return results[0], nil
+}
+
+// isEmptyMergedResult reports whether the result of handleMergedRequest has no items.
+func isEmptyMergedResult(res any) bool {
+	if v, ok := res.(*protocol.CompletionList); ok {
+		// an incomplete list asks the client to complete again
+		return len(v.Items) == 0 && !v.IsIncomplete
+	}
+	b, err := json.Marshal(res)
+	return err == nil && isEmptyResult(b)
+}
+
+// clientCaps returns the client capabilities received by initialize.
+func (h *ClientHandler) clientCaps() map[string]any {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.clientCapabilities
+}
+
+func (h *ClientHandler) handleInitializeRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	var params struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	h.clientCapabilities = params.Capabilities
+	h.initParams = r.Params
+	h.mu.Unlock()
+	h.diagRegistry.SetRefreshSupport(capability.IsEnabled(params.Capabilities, "workspace.diagnostics.refreshSupport"))
 
+	merged := map[string]any{}
+	workspaceDiagnostics := false
+	for _, server := range servers {
+		kvCaps, err := h.initializeServer(ctx, server, r.Params)
+		if err != nil {
+			return nil, err
+		}
 		// respect the preceding value
 		capability.Merge(merged, kvCaps)
-		server.Capabilities = &typedRes.Capabilities
-		server.SupportedCapabilities = capability.CollectSupported(kvCaps)
+		workspaceDiagnostics = workspaceDiagnostics || capability.IsEnabled(kvCaps, "diagnosticProvider.workspaceDiagnostics")
+	}
 
-		slog.DebugContext(ctx, "server capabilities",
-			"server", server.Name,
-			"capabilities", kvCaps,
-			"supportedCapabilities", slices.Collect(maps.Keys(server.SupportedCapabilities)))
+	// workspace diagnostics are merged from servers supporting it
+	if diagProvider, ok := merged["diagnosticProvider"].(map[string]any); ok && workspaceDiagnostics {
+		diagProvider["workspaceDiagnostics"] = true
 	}
+	h.bridgeDiagnosticCapabilities(merged)
+
+	if enc, ok := merged["positionEncoding"].(string); ok {
+		h.documents.SetPositionEncoding(protocol.PositionEncodingKind(enc))
+	}
+
+	// lazy servers are started by didOpen
+	if _, ok := merged["textDocumentSync"]; !ok && len(h.lazyServers) != 0 {
+		merged["textDocumentSync"] = map[string]any{
+			"openClose": true,
+			"change":    protocol.TextDocumentSyncKindIncremental,
+		}
+	}
+
+	h.mu.Lock()
+	h.serverCapabilities = maps.Clone(merged)
+	h.mu.Unlock()
 
 	return map[string]any{
 		"serverInfo": map[string]any{
@@ -129,6 +426,118 @@ func (h *ClientHandler) handleInitializeRequest(ctx context.Context, r *jsonrpc2
 	}, nil
 }
 
+// initializeServer sends the initialize request to the server and returns the server capabilities.
+func (h *ClientHandler) initializeServer(ctx context.Context, server *ServerConnection, params json.RawMessage) (map[string]any, error) {
+	var kvParams map[string]any
+	if err := json.Unmarshal(params, &kvParams); err != nil {
+		return nil, err
+	}
+
+	// override initializationOptions if configured
+	if len(server.InitOptions) != 0 {
+		slog.DebugContext(ctx, "override initializationOptions", "server", server.Name, "initOptions", server.InitOptions)
+		kvParams["initializationOptions"] = server.InitOptions
+	}
+	h.bridgeDiagnosticClientCapabilities(kvParams)
+
+	var rawRes json.RawMessage
+	if err := server.Call(ctx, string(protocol.InitializeMethod), kvParams, &rawRes); err != nil {
+		return nil, err
+	}
+
+	var typedRes protocol.InitializeResult
+	if err := json.Unmarshal(rawRes, &typedRes); err != nil {
+		return nil, err
+	}
+
+	var kvRes map[string]any
+	if err := json.Unmarshal(rawRes, &kvRes); err != nil {
+		return nil, err
+	}
+
+	kvCaps, ok := kvRes["capabilities"].(map[string]any)
+	if !ok {
+		return nil, errors.New("no capabilities in initialize response")
+	}
+
+	if h.cfg.Formatter.HideOthers && !h.cfg.Formatter.IsFormatter(server.Name) {
+		for _, key := range formattingCapabilities {
+			delete(kvCaps, key)
+		}
+	}
+
+	supported := capability.CollectSupported(kvCaps)
+	server.SetCapabilities(&typedRes.Capabilities, supported)
+	// pull diagnostics of servers are published by lsmux
+	if h.cfg.Diagnostics.Mode == DiagnosticModePush {
+		delete(kvCaps, "diagnosticProvider")
+	}
+
+	slog.DebugContext(ctx, "server capabilities",
+		"server", server.Name,
+		"capabilities", kvCaps,
+		"supportedCapabilities", slices.Collect(maps.Keys(supported)))
+
+	return kvCaps, nil
+}
+
+// RestoreServer initializes the restarted server with the initialize params of the client, and opens the documents opened by the client.
+// The server becomes ready to receive messages of the client after the session is restored.
+// Capabilities of a lazy server are registered to the client.
+func (h *ClientHandler) RestoreServer(ctx context.Context, server *ServerConnection) error {
+	h.mu.Lock()
+	initParams := h.initParams
+	h.mu.Unlock()
+
+	// the client will initialize the server
+	if initParams == nil {
+		server.SetReady()
+		return nil
+	}
+
+	kvCaps, err := h.initializeServer(ctx, server, initParams)
+	if err != nil {
+		return err
+	}
+	if err := server.Notify(ctx, string(protocol.InitializedMethod), protocol.InitializedParams{}); err != nil {
+		return err
+	}
+	if err := h.openDocuments(ctx, server); err != nil {
+		return err
+	}
+
+	if server.Lazy {
+		return h.registerCapabilities(ctx, server, kvCaps)
+	}
+	return nil
+}
+
+// openDocuments opens the documents opened by the client in the server, and marks the server ready.
+func (h *ClientHandler) openDocuments(ctx context.Context, server *ServerConnection) error {
+	// documents must not be changed until the server becomes ready
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+
+	for _, doc := range h.documents.List() {
+		if !server.MatchDocument(doc) {
+			continue
+		}
+		params := protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{
+				Uri:        doc.URI,
+				LanguageId: doc.LanguageID,
+				Version:    doc.ServerVersion(),
+				Text:       doc.Text,
+			},
+		}
+		if err := server.Notify(ctx, string(protocol.TextDocumentDidOpenMethod), params); err != nil {
+			return err
+		}
+	}
+	server.SetReady()
+	return nil
+}
+
 func (h *ClientHandler) handleExecuteCommandRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
 	var params protocol.ExecuteCommandParams
 	if err := json.Unmarshal(r.Params, &params); err != nil {
@@ -143,15 +552,111 @@ func (h *ClientHandler) handleExecuteCommandRequest(ctx context.Context, r *json
 	return server.CallWithRawResult(ctx, r.Method, r.Params)
 }
 
+// handleFormattingRequest sends formatting requests to the server selected by the formatter config.
+func (h *ClientHandler) handleFormattingRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	uri := textDocumentURI(r.Params)
+	doc, found := h.documents.Get(uri)
+	if !found {
+		doc = Document{URI: uri}
+	}
+
+	if rule, found := h.cfg.Formatter.Lookup(doc); found {
+		if len(rule.Pipeline) != 0 && protocol.MethodKind(r.Method) == protocol.TextDocumentFormattingMethod {
+			return h.handleFormattingPipeline(ctx, r, servers, uri, rule.Pipeline)
+		}
+
+		// use the first server of the pipeline for range and on type formatting
+		for _, name := range rule.Servers() {
+			if server, found := servers.FindByName(name); found {
+				return server.CallWithRawResult(ctx, r.Method, r.Params)
+			}
+		}
+		slog.DebugContext(ctx, "formatter does not support the method", "servers", rule.Servers())
+		// other servers never format the document
+		if h.cfg.Formatter.HideOthers {
+			return json.RawMessage("null"), nil
+		}
+	}
+
+	return h.handleRoutedRequest(ctx, r, servers)
+}
+
+// handleFormattingPipeline formats the document with servers in the pipeline order.
+// Each server receives the result of the preceding server by a synthetic didChange notification,
+// and the original content is restored after formatting.
+func (h *ClientHandler) handleFormattingPipeline(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList, uri protocol.DocumentUri, pipeline []string) (any, error) {
+	doc, found := h.documents.Get(uri)
+	if !found {
+		return nil, fmt.Errorf("document not opened: %s", uri)
+	}
+	enc := h.documents.PositionEncoding()
+
+	didChange := func(servers ServerConnectionList, text string) error {
+		version, err := h.documents.NextSyntheticVersion(uri)
+		if err != nil {
+			return err
+		}
+		for _, server := range servers {
+			err := server.Notify(ctx, string(protocol.TextDocumentDidChangeMethod), protocol.DidChangeTextDocumentParams{
+				TextDocument: protocol.VersionedTextDocumentIdentifier{Uri: uri, Version: version},
+				ContentChanges: []protocol.TextDocumentContentChangeEvent{
+					{Value: protocol.TextDocumentContentChangeWholeDocument{Text: text}},
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to change document of %s: %w", server.Name, err)
+			}
+		}
+		return nil
+	}
+
+	var changedServers ServerConnectionList
+	defer func() {
+		if len(changedServers) == 0 {
+			return
+		}
+		// the document may be changed while formatting
+		cur, found := h.documents.Get(uri)
+		if !found {
+			return
+		}
+		if err := didChange(changedServers, cur.Text); err != nil {
+			slog.WarnContext(ctx, "failed to restore document", "error", err)
+		}
+	}()
+
+	text := doc.Text
+	for _, name := range pipeline {
+		server, found := servers.FindByName(name)
+		if !found {
+			slog.DebugContext(ctx, "formatter does not support the method", "server", name)
+			continue
+		}
+
+		if text != doc.Text {
+			changedServers = append(changedServers, server)
+			if err := didChange(ServerConnectionList{server}, text); err != nil {
+				return nil, err
+			}
+		}
+
+		var edits []protocol.TextEdit
+		if err := server.Call(ctx, r.Method, r.Params, &edits); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if text, err = applyTextEdits(text, edits, enc); err != nil {
+			return nil, fmt.Errorf("failed to apply edits from %s: %w", server.Name, err)
+		}
+	}
+
+	return diffTextEdits(doc.Text, text, enc), nil
+}
+
 func (h *ClientHandler) handleCompletionRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
 	results := SliceFor(protocol.CompletionResponse{}.Result, len(servers))
-	g, gctx := errgroup.WithContext(ctx)
-	for i, server := range servers {
-		g.Go(func() error {
-			return server.Call(gctx, r.Method, r.Params, &results[i])
-		})
-	}
-	if err := g.Wait(); err != nil {
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
 		return nil, err
 	}
 
@@ -163,30 +668,272 @@ func (h *ClientHandler) handleCompletionRequest(ctx context.Context, r *jsonrpc2
 	}
 
 	res.Items = []protocol.CompletionItem{}
-	for _, r := range results {
+	for i, r := range results {
+		var items []protocol.CompletionItem
+		var defaultData any
 		switch v := r.Value.(type) {
 		case []protocol.CompletionItem:
-			res.Items = append(res.Items, v...)
+			items = v
 		case protocol.CompletionList:
-			res.Items = append(res.Items, v.Items...)
+			items = v.Items
+			if v.ItemDefaults != nil {
+				defaultData = v.ItemDefaults.Data
+			}
 		case nil: // do nothing
 		default:
 			panic(fmt.Sprintf("invalid completion result type: %T", v))
 		}
+
+		for _, item := range items {
+			// add server name to completion item data for future resolve
+			if item.Data == nil {
+				item.Data = defaultData
+			}
+			item.Data = wrapServerData(servers[i].Name, item.Data)
+			res.Items = append(res.Items, item)
+		}
+	}
+	// every item has its own data now
+	if res.ItemDefaults != nil {
+		res.ItemDefaults.Data = nil
 	}
 
 	return &res, nil
 }
 
-const codeActionDataServerKey = "lsmux.server"
-const codeActionDataOriginalDataKey = "lsmux.originalData"
+func (h *ClientHandler) handleCompletionItemResolveRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	params := protocol.CompletionResolveRequest{}.Params
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	server, originalData, found := h.resolveServer(r.Method, servers, params.Data)
+	if !found {
+		// the server does not support resolve
+		return r.Params, nil
+	}
+	params.Data = originalData
+
+	var res protocol.CompletionItem
+	if err := server.Call(ctx, r.Method, params, &res); err != nil {
+		return nil, err
+	}
+	// keep server name for subsequent resolve
+	res.Data = wrapServerData(server.Name, res.Data)
+
+	return &res, nil
+}
+
+// handleDefinitionRequest merges results of definition, declaration, typeDefinition and implementation requests.
+func (h *ClientHandler) handleDefinitionRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]json.RawMessage, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	var links []protocol.LocationLink
+	for i, res := range results {
+		v, err := parseLocationLinks(res)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s result from %s: %w", r.Method, servers[i].Name, err)
+		}
+		links = append(links, v...)
+	}
+
+	if locationLinkSupported(h.clientCaps(), r.Method) {
+		return dedupLocationLinks(links), nil
+	}
+
+	var locs []protocol.Location
+	for _, link := range links {
+		locs = append(locs, locationLinkToLocation(link))
+	}
+	return dedupLocations(locs), nil
+}
+
+func (h *ClientHandler) handleReferencesRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([][]protocol.Location, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	return dedupLocations(slices.Concat(results...)), nil
+}
+
+func (h *ClientHandler) handleHoverRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]json.RawMessage, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	merger := NewHoverMerger(h.cfg.Hover, hoverContentFormat(h.clientCaps()))
+	for i, res := range results {
+		if err := merger.Add(servers[i].Name, res); err != nil {
+			return nil, fmt.Errorf("invalid hover result from %s: %w", servers[i].Name, err)
+		}
+	}
+
+	return merger.Result(), nil
+}
+
+func (h *ClientHandler) handleDocumentSymbolRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]json.RawMessage, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	hierarchical := capability.IsEnabled(h.clientCaps(), "textDocument.documentSymbol.hierarchicalDocumentSymbolSupport")
+	merger := NewDocumentSymbolMerger(textDocumentURI(r.Params), hierarchical)
+	for i, res := range results {
+		if err := merger.Add(res); err != nil {
+			return nil, fmt.Errorf("invalid document symbol result from %s: %w", servers[i].Name, err)
+		}
+	}
+
+	return merger.Result(), nil
+}
+
+func (h *ClientHandler) handleWorkspaceSymbolRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([]json.RawMessage, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	merger := NewWorkspaceSymbolMerger(h.cfg.WorkspaceSymbol.MaxResultsPerServer)
+	for i, res := range results {
+		if err := merger.Add(servers[i].Name, res); err != nil {
+			return nil, fmt.Errorf("invalid workspace symbol result from %s: %w", servers[i].Name, err)
+		}
+	}
+
+	return merger.Result(), nil
+}
+
+func (h *ClientHandler) handleWorkspaceSymbolResolveRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	params := protocol.WorkspaceSymbolResolveRequest{}.Params
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	return h.resolveWithServerData(ctx, r, servers, &params, &params.Data)
+}
+
+func (h *ClientHandler) handleCodeLensRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([][]protocol.CodeLens, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	res := []protocol.CodeLens{}
+	for i, lenses := range results {
+		for _, lens := range lenses {
+			// add server name to code lens data for future resolve
+			lens.Data = wrapServerData(servers[i].Name, lens.Data)
+			res = append(res, lens)
+		}
+	}
+
+	return res, nil
+}
+
+func (h *ClientHandler) handleCodeLensResolveRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	params := protocol.CodeLensResolveRequest{}.Params
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	return h.resolveWithServerData(ctx, r, servers, &params, &params.Data)
+}
+
+func (h *ClientHandler) handleInlayHintRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	results := make([][]protocol.InlayHint, len(servers))
+	if err := CallServers(ctx, servers, r.Method, r.Params, results); err != nil {
+		return nil, err
+	}
+
+	var res []protocol.InlayHint
+	for i, hints := range results {
+		for _, hint := range hints {
+			// add server name to inlay hint data for future resolve
+			hint.Data = wrapServerData(servers[i].Name, hint.Data)
+			res = append(res, hint)
+		}
+	}
+
+	return dedupInlayHints(res), nil
+}
+
+func (h *ClientHandler) handleInlayHintResolveRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	params := protocol.InlayHintResolveRequest{}.Params
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	return h.resolveWithServerData(ctx, r, servers, &params, &params.Data)
+}
+
+// resolveWithServerData sends the resolve request to the server that produced the item.
+// data should point to the data field of params, which is wrapped by wrapServerData.
+// The item is returned as is if the server does not support resolve.
+func (h *ClientHandler) resolveWithServerData(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList, params any, data *any) (any, error) {
+	server, originalData, found := h.resolveServer(r.Method, servers, *data)
+	if !found {
+		return r.Params, nil
+	}
+	*data = originalData
 
+	var res map[string]any
+	if err := server.Call(ctx, r.Method, params, &res); err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return json.RawMessage("null"), nil
+	}
+	// keep server name for subsequent resolve
+	res["data"] = wrapServerData(server.Name, res["data"])
+
+	return res, nil
+}
+
+// resolveServer returns the server that produced the item with data wrapped by wrapServerData, and the original data.
+// Untagged data (e.g. an item the client created by itself) is resolved by the first server selected by routing.
+func (h *ClientHandler) resolveServer(method string, servers ServerConnectionList, data any) (*ServerConnection, any, bool) {
+	serverName, originalData, err := unwrapServerData(data)
+	if err != nil {
+		servers = lookupRouting(h.cfg.Routing, method).Select(servers)
+		if len(servers) == 0 {
+			return nil, nil, false
+		}
+		return servers[0], data, true
+	}
+
+	server, found := servers.FindByName(serverName)
+	return server, originalData, found
+}
+
+// handleCodeActionRequest merges code actions from servers.
+// Each server receives only the diagnostics it reported, and diagnostics of unknown servers.
+// If all diagnostics in the context are tagged with their servers, the request is sent only to those servers among the given ones.
 func (h *ClientHandler) handleCodeActionRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	params := protocol.CodeActionRequest{}.Params
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	serverDiags, allTagged := splitCodeActionDiagnostics(h.diagRegistry, params.TextDocument.Uri, params.Context.Diagnostics)
+	// the routed servers may not include the tagged servers
+	if tagged := servers.FilterByNames(slices.Collect(maps.Keys(serverDiags))); allTagged && len(tagged) != 0 {
+		servers = tagged
+	}
+
 	results := SliceFor(protocol.CodeActionResponse{}.Result, len(servers))
 	g, gctx := errgroup.WithContext(ctx)
 	for i, server := range servers {
+		serverParams := params
+		// diagnostics is a required field
+		serverParams.Context.Diagnostics = append([]protocol.Diagnostic{}, slices.Concat(serverDiags[server.Name], serverDiags[""])...)
 		g.Go(func() error {
-			return server.Call(gctx, r.Method, r.Params, &results[i])
+			return server.Call(gctx, r.Method, serverParams, &results[i])
 		})
 	}
 	if err := g.Wait(); err != nil {
@@ -198,7 +945,7 @@ func (h *ClientHandler) handleCodeActionRequest(ctx context.Context, r *jsonrpc2
 		for _, action := range Deref(r) {
 			if v, ok := action.Value.(protocol.CodeAction); ok {
 				// add server name to code action data for future resolve
-				v.Data = map[string]any{codeActionDataServerKey: servers[i].Name, codeActionDataOriginalDataKey: v.Data}
+				v.Data = wrapServerData(servers[i].Name, v.Data)
 				action.Value = v
 			}
 			res = append(res, action)
@@ -214,30 +961,173 @@ func (h *ClientHandler) handleCodeActionResolveRequest(ctx context.Context, r *j
 		return nil, err
 	}
 
-	extractData := func() (string, any, error) {
-		data, ok := params.Data.(map[string]any)
-		if !ok {
-			return "", nil, fmt.Errorf("invalid code action data")
+	server, originalData, found := h.resolveServer(r.Method, servers, params.Data)
+	if !found {
+		return nil, ErrMethodNotFound
+	}
+	params.Data = originalData
+
+	return server.CallWithRawResult(ctx, r.Method, params)
+}
+
+// handleDocumentDiagnosticRequest merges pull diagnostics from servers.
+// The result ID for the client combines result IDs of servers, and it is split into each server's previousResultId.
+func (h *ClientHandler) handleDocumentDiagnosticRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	var params struct {
+		TextDocument     protocol.TextDocumentIdentifier `json:"textDocument"`
+		PreviousResultID string                          `json:"previousResultId"`
+	}
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+	prevIDs := decodeResultID(params.PreviousResultID)
+
+	results := make([]json.RawMessage, len(servers))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, server := range servers {
+		g.Go(func() error {
+			var kvParams map[string]any
+			if err := json.Unmarshal(r.Params, &kvParams); err != nil {
+				return err
+			}
+			delete(kvParams, "previousResultId")
+			if id, ok := prevIDs[server.Name]; ok {
+				kvParams["previousResultId"] = id
+			}
+			res, err := h.callDocumentDiagnostic(gctx, server, params.TextDocument.Uri, kvParams)
+			results[i] = res
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	merger := NewDocumentDiagnosticMerger(h.diagReports, servers.DiagnosticsConfigs(), params.TextDocument.Uri)
+	for i, res := range results {
+		if err := merger.Add(servers[i].Name, res); err != nil {
+			return nil, fmt.Errorf("invalid document diagnostic report from %s: %w", servers[i].Name, err)
 		}
-		serverName, ok := data[codeActionDataServerKey].(string)
-		if !ok {
-			return "", nil, fmt.Errorf("%s not found in code action data", codeActionDataServerKey)
+	}
+	if h.cfg.Diagnostics.Mode == DiagnosticModePull {
+		uri := params.TextDocument.Uri
+		merger.AddPushed(h.pushedResultID(uri), h.diagRegistry.GetDiagnostics(uri))
+	}
+
+	return merger.Result(params.PreviousResultID), nil
+}
+
+// callDocumentDiagnostic sends the document diagnostic request to the server.
+// If the server reports unchanged for a result which is not cached (e.g. after lsmux or the server restarted, or the document was closed),
+// the full report is requested again, since the diagnostics of the result are unknown.
+func (h *ClientHandler) callDocumentDiagnostic(ctx context.Context, server *ServerConnection, uri protocol.DocumentUri, params map[string]any) (json.RawMessage, error) {
+	method := string(protocol.TextDocumentDiagnosticMethod)
+	var res json.RawMessage
+	if err := server.Call(ctx, method, params, &res); err != nil {
+		return nil, err
+	}
+	if _, ok := params["previousResultId"]; !ok || isEmptyResult(res) {
+		return res, nil
+	}
+
+	var report documentDiagnosticReport
+	if err := json.Unmarshal(res, &report); err != nil {
+		return nil, err
+	}
+	if report.Kind != protocol.DocumentDiagnosticReportKindUnchanged || h.diagReports.ResultID(uri, server.Name) == report.ResultID {
+		return res, nil
+	}
+
+	delete(params, "previousResultId")
+	res = nil
+	if err := server.Call(ctx, method, params, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// handleWorkspaceDiagnosticRequest merges workspace pull diagnostics from servers by URI.
+// Partial results of servers are merged and sent to the client with the client's token.
+func (h *ClientHandler) handleWorkspaceDiagnosticRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
+	var params struct {
+		PreviousResultIDs  []protocol.PreviousResultId `json:"previousResultIds"`
+		PartialResultToken any                         `json:"partialResultToken"`
+	}
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	prevIDs := map[protocol.DocumentUri]string{}
+	for _, id := range params.PreviousResultIDs {
+		prevIDs[id.Uri] = id.Value
+	}
+	merger := NewWorkspaceDiagnosticMerger(h.diagReports, servers.DiagnosticsConfigs(), prevIDs)
+
+	streaming := params.PartialResultToken != nil
+	sendPartialResult := func(ctx context.Context, items []workspaceDocumentDiagnosticReport) error {
+		if len(items) == 0 {
+			return nil
 		}
-		return serverName, data[codeActionDataOriginalDataKey], nil
+		return h.clientConn.Notify(ctx, string(protocol.OptionalProgressMethod), map[string]any{
+			"token": params.PartialResultToken,
+			"value": map[string]any{"items": items},
+		})
 	}
 
-	serverName, originalData, err := extractData()
-	if err != nil {
+	results := make([]json.RawMessage, len(servers))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, server := range servers {
+		g.Go(func() error {
+			var kvParams map[string]any
+			if err := json.Unmarshal(r.Params, &kvParams); err != nil {
+				return err
+			}
+
+			// the server can report unchanged only for cached results, whose diagnostics are merged with other servers
+			serverPrevIDs := []protocol.PreviousResultId{}
+			for _, id := range params.PreviousResultIDs {
+				if value, ok := decodeResultID(id.Value)[server.Name]; ok && value == h.diagReports.ResultID(id.Uri, server.Name) {
+					serverPrevIDs = append(serverPrevIDs, protocol.PreviousResultId{Uri: id.Uri, Value: value})
+				}
+			}
+			kvParams["previousResultIds"] = serverPrevIDs
+
+			if streaming {
+				token, unregister := h.partialResults.Register(server.Name, func(ctx context.Context, value json.RawMessage) error {
+					items, err := merger.Add(server.Name, value)
+					if err != nil {
+						return err
+					}
+					return sendPartialResult(ctx, items)
+				})
+				defer unregister()
+				kvParams["partialResultToken"] = token
+			}
+
+			return server.Call(gctx, r.Method, kvParams, &results[i])
+		})
+	}
+	if err := g.Wait(); err != nil {
 		return nil, err
 	}
-	params.Data = originalData
 
-	server, found := servers.FindByName(serverName)
-	if !found {
-		return nil, ErrMethodNotFound
+	for i, res := range results {
+		items, err := merger.Add(servers[i].Name, res)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace diagnostic report from %s: %w", servers[i].Name, err)
+		}
+		if streaming {
+			if err := sendPartialResult(ctx, items); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return server.CallWithRawResult(ctx, r.Method, params)
+	// the whole result is reported by partial results if the client requested them
+	if streaming {
+		return map[string]any{"items": []any{}}, nil
+	}
+	return map[string]any{"items": merger.Result()}, nil
 }
 
 func (h *ClientHandler) handleShutdownRequest(ctx context.Context, r *jsonrpc2.Request, servers ServerConnectionList) (any, error) {
@@ -245,6 +1135,7 @@ func (h *ClientHandler) handleShutdownRequest(ctx context.Context, r *jsonrpc2.R
 	for _, server := range servers {
 		g.Go(func() error {
 			log := slog.With("server", server.Name)
+			server.Stop()
 			if err := server.Call(ctx, r.Method, r.Params, nil); err != nil {
 				log.WarnContext(ctx, "shutdown error", "error", err)
 			}
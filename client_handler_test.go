@@ -0,0 +1,707 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buzztaiki/lsmux/capability"
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/exp/jsonrpc2"
+)
+
+// newTestConnection returns a connection to a peer that handles requests with handler.
+func newTestConnection(t *testing.T, handler jsonrpc2.HandlerFunc) *jsonrpc2.Connection {
+	t.Helper()
+	ctx := context.Background()
+
+	l, err := jsonrpc2.NetPipe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if _, err := jsonrpc2.Serve(ctx, l, NewBinder(handler)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := jsonrpc2.Dial(ctx, l.Dialer(), NewBinder(jsonrpc2.HandlerFunc(func(context.Context, *jsonrpc2.Request) (any, error) {
+		return nil, jsonrpc2.ErrNotHandled
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestServer returns a started server with the capabilities that handles requests with handler.
+func newTestServer(t *testing.T, name string, kvCaps map[string]any, handler jsonrpc2.HandlerFunc) *ServerConnection {
+	t.Helper()
+
+	server := &ServerConnection{Name: name}
+	server.SetCapabilities(&protocol.ServerCapabilities{}, capability.CollectSupported(kvCaps))
+	server.setConnection(newTestConnection(t, handler))
+	server.SetReady()
+	return server
+}
+
+func newTestClientHandler(cfg *Config, servers ...*ServerConnection) *ClientHandler {
+	serverRegistry := NewServerConnectionRegistry(len(servers))
+	serverNames := make([]string, len(servers))
+	for i, server := range servers {
+		serverRegistry.Add(context.Background(), server)
+		serverNames[i] = server.Name
+	}
+	return NewClientHandler(serverRegistry, NewDocumentStore(), NewDiagnosticRegistry(cfg.Diagnostics, serverNames), NewPartialResultRouter(), cfg)
+}
+
+// testCall sends a request to the handler and returns the result as JSON.
+func testCall(t *testing.T, h jsonrpc2.Handler, method string, params any) json.RawMessage {
+	t.Helper()
+
+	r, err := jsonrpc2.NewCall(jsonrpc2.Int64ID(1), method, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := h.Handle(context.Background(), r)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// testNotify sends a notification to the handler.
+func testNotify(t *testing.T, h jsonrpc2.Handler, method string, params any) {
+	t.Helper()
+
+	r, err := jsonrpc2.NewNotification(method, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Handle(context.Background(), r); err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+}
+
+// echoResolveHandler returns a handler that answers the request with items carrying data,
+// and answers resolve with the item whose field is set to "resolved by <serverName>".
+func echoResolveHandler(serverName, method, field string, items ...map[string]any) jsonrpc2.HandlerFunc {
+	return func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		switch r.Method {
+		case method:
+			return items, nil
+		default:
+			var item map[string]any
+			if err := json.Unmarshal(r.Params, &item); err != nil {
+				return nil, err
+			}
+			item[field] = "resolved by " + serverName
+			return item, nil
+		}
+	}
+}
+
+func TestRoutingServerFailure(t *testing.T) {
+	kvCaps := map[string]any{"foldingRangeProvider": true, "completionProvider": map[string]any{}}
+	broken := newTestServer(t, "broken", kvCaps, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		return nil, errors.New("broken")
+	})
+	ok := newTestServer(t, "ok", kvCaps, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		if r.Method == "textDocument/completion" {
+			return []map[string]any{{"label": "ok"}}, nil
+		}
+		return []map[string]any{{"startLine": 1, "endLine": 2}}, nil
+	})
+
+	tests := []struct {
+		name     string
+		strategy RoutingStrategyKind
+		method   string
+		servers  []*ServerConnection
+		want     string
+		wantErr  bool
+	}{
+		{name: "first-non-empty", strategy: RoutingFirstNonEmpty, method: "textDocument/foldingRange", servers: []*ServerConnection{broken, ok}, want: `[{"endLine":2,"startLine":1}]`},
+		{name: "first-non-empty merged", strategy: RoutingFirstNonEmpty, method: "textDocument/completion", servers: []*ServerConnection{broken, ok}, want: `ok`},
+		{name: "all-merge", strategy: RoutingAllMerge, method: "textDocument/foldingRange", servers: []*ServerConnection{broken, ok}, want: `[{"endLine":2,"startLine":1}]`},
+		{name: "all-merge merged", strategy: RoutingAllMerge, method: "textDocument/completion", servers: []*ServerConnection{broken, ok}, want: `ok`},
+		{name: "all servers failed", strategy: RoutingAllMerge, method: "textDocument/foldingRange", servers: []*ServerConnection{broken}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Routing: RoutingTable{tt.method: {Strategy: tt.strategy}}}
+			h := newTestClientHandler(cfg, tt.servers...)
+			params := map[string]any{"textDocument": map[string]any{"uri": "file:///a.py"}, "position": map[string]any{"line": 0, "character": 0}}
+
+			if tt.wantErr {
+				r, err := jsonrpc2.NewCall(jsonrpc2.Int64ID(1), tt.method, params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := h.Handle(context.Background(), r); err == nil {
+					t.Errorf("%s: expected error, got nil", tt.method)
+				}
+				return
+			}
+
+			got := string(testCall(t, h, tt.method, params))
+			if tt.method == "textDocument/completion" {
+				var res protocol.CompletionList
+				if err := json.Unmarshal([]byte(got), &res); err != nil {
+					t.Fatal(err)
+				}
+				var labels []string
+				for _, item := range res.Items {
+					labels = append(labels, item.Label)
+				}
+				got = strings.Join(labels, ",")
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("%s mismatch (-want +got):\n%s", tt.method, diff)
+			}
+		})
+	}
+}
+
+func TestResolveWithFirstRouting(t *testing.T) {
+	kvCaps := map[string]any{"codeActionProvider": map[string]any{"resolveProvider": true}}
+	eslint := newTestServer(t, "eslint", kvCaps, echoResolveHandler("eslint", "textDocument/codeAction", "detail",
+		map[string]any{"title": "eslint fix", "data": "eslint data"}))
+	pyright := newTestServer(t, "pyright", kvCaps, echoResolveHandler("pyright", "textDocument/codeAction", "detail",
+		map[string]any{"title": "pyright fix", "data": "pyright data"}))
+	cfg := &Config{Routing: RoutingTable{"textDocument/*": {Strategy: RoutingFirst, Exclude: []string{"eslint"}}}}
+	h := newTestClientHandler(cfg, eslint, pyright)
+
+	params := map[string]any{
+		"textDocument": map[string]any{"uri": "file:///a.py"},
+		"range":        map[string]any{"start": map[string]any{"line": 0, "character": 0}, "end": map[string]any{"line": 0, "character": 0}},
+		"context":      map[string]any{"diagnostics": []any{}},
+	}
+	var actions []map[string]any
+	if err := json.Unmarshal(testCall(t, h, "textDocument/codeAction", params), &actions); err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 {
+		t.Fatalf("codeAction result = %v, want 1 action", actions)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(testCall(t, h, "codeAction/resolve", actions[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"title": "pyright fix", "data": "pyright data", "detail": "resolved by pyright"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("codeAction/resolve mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompletionItemResolve(t *testing.T) {
+	resolvable := map[string]any{"completionProvider": map[string]any{"resolveProvider": true}}
+	unresolvable := map[string]any{"completionProvider": map[string]any{}}
+	newHandler := func() *ClientHandler {
+		ruff := newTestServer(t, "ruff", unresolvable, echoResolveHandler("ruff", "textDocument/completion", "detail",
+			map[string]any{"label": "ruff item", "data": "ruff data"}))
+		pyright := newTestServer(t, "pyright", resolvable, echoResolveHandler("pyright", "textDocument/completion", "detail",
+			map[string]any{"label": "pyright item", "data": "pyright data"}))
+		return newTestClientHandler(&Config{}, ruff, pyright)
+	}
+
+	completionParams := map[string]any{
+		"textDocument": map[string]any{"uri": "file:///a.py"},
+		"position":     map[string]any{"line": 0, "character": 0},
+	}
+	completionItem := func(t *testing.T, h *ClientHandler, label string) map[string]any {
+		var list struct {
+			Items []map[string]any `json:"items"`
+		}
+		if err := json.Unmarshal(testCall(t, h, "textDocument/completion", completionParams), &list); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range list.Items {
+			if item["label"] == label {
+				return item
+			}
+		}
+		t.Fatalf("completion item %s not found in %v", label, list.Items)
+		return nil
+	}
+
+	tests := []struct {
+		name string
+		item func(t *testing.T, h *ClientHandler) map[string]any
+		want map[string]any
+	}{
+		{
+			name: "originating server",
+			item: func(t *testing.T, h *ClientHandler) map[string]any { return completionItem(t, h, "pyright item") },
+			want: map[string]any{"label": "pyright item", "data": wrapServerData("pyright", "pyright data"), "detail": "resolved by pyright"},
+		},
+		{
+			name: "server without resolveProvider",
+			item: func(t *testing.T, h *ClientHandler) map[string]any { return completionItem(t, h, "ruff item") },
+			want: map[string]any{"label": "ruff item", "data": wrapServerData("ruff", "ruff data")},
+		},
+		{
+			name: "untagged item",
+			item: func(t *testing.T, h *ClientHandler) map[string]any {
+				return map[string]any{"label": "client item", "data": "client data"}
+			},
+			want: map[string]any{"label": "client item", "data": wrapServerData("pyright", "client data"), "detail": "resolved by pyright"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHandler()
+
+			var got map[string]any
+			if err := json.Unmarshal(testCall(t, h, "completionItem/resolve", tt.item(t, h)), &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("completionItem/resolve mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWorkspaceSymbolResolve(t *testing.T) {
+	kvCaps := map[string]any{"workspaceSymbolProvider": map[string]any{"resolveProvider": true}}
+	symbol := func(name string) map[string]any {
+		return map[string]any{"name": name, "kind": 12.0, "location": map[string]any{"uri": "file:///" + name + ".py"}, "data": name + " data"}
+	}
+	ruff := newTestServer(t, "ruff", kvCaps, echoResolveHandler("ruff", "workspace/symbol", "containerName", symbol("ruff")))
+	pyright := newTestServer(t, "pyright", kvCaps, echoResolveHandler("pyright", "workspace/symbol", "containerName", symbol("pyright")))
+	h := newTestClientHandler(&Config{}, ruff, pyright)
+
+	var symbols []map[string]any
+	if err := json.Unmarshal(testCall(t, h, "workspace/symbol", map[string]any{"query": ""}), &symbols); err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 2 {
+		t.Fatalf("workspace/symbol result = %v, want 2 symbols", symbols)
+	}
+
+	want := symbol("pyright")
+	want["data"] = wrapServerData("pyright", "pyright data")
+	want["containerName"] = "resolved by pyright"
+	// the resolved symbol can be resolved again
+	for range 2 {
+		var got map[string]any
+		if err := json.Unmarshal(testCall(t, h, "workspaceSymbol/resolve", symbols[1]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("workspaceSymbol/resolve mismatch (-want +got):\n%s", diff)
+		}
+		symbols[1] = got
+	}
+}
+
+func TestReferences(t *testing.T) {
+	loc := func(line uint32) map[string]any {
+		return map[string]any{"uri": "file:///a.py", "range": rng(line, 0, line, 1)}
+	}
+	referencesHandler := func(res any) jsonrpc2.HandlerFunc {
+		return func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+			return res, nil
+		}
+	}
+	kvCaps := map[string]any{"referencesProvider": true}
+	ruff := newTestServer(t, "ruff", kvCaps, referencesHandler(json.RawMessage("null")))
+	pyright := newTestServer(t, "pyright", kvCaps, referencesHandler([]any{loc(1), loc(2)}))
+	mypy := newTestServer(t, "mypy", kvCaps, referencesHandler([]any{loc(2), loc(3)}))
+	h := newTestClientHandler(&Config{}, ruff, pyright, mypy)
+
+	params := map[string]any{
+		"textDocument": map[string]any{"uri": "file:///a.py"},
+		"position":     map[string]any{"line": 1, "character": 0},
+		"context":      map[string]any{"includeDeclaration": true},
+	}
+	var got []protocol.Location
+	if err := json.Unmarshal(testCall(t, h, "textDocument/references", params), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []protocol.Location{
+		{Uri: "file:///a.py", Range: rng(1, 0, 1, 1)},
+		{Uri: "file:///a.py", Range: rng(2, 0, 2, 1)},
+		{Uri: "file:///a.py", Range: rng(3, 0, 3, 1)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("references mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCodeLens(t *testing.T) {
+	resolvable := map[string]any{"codeLensProvider": map[string]any{"resolveProvider": true}}
+	lens := func(serverName string, line int) map[string]any {
+		return map[string]any{
+			"range": map[string]any{"start": map[string]any{"line": line, "character": 0}, "end": map[string]any{"line": line, "character": 0}},
+			"data":  serverName + " data",
+		}
+	}
+	ruff := newTestServer(t, "ruff", map[string]any{"codeLensProvider": map[string]any{}},
+		echoResolveHandler("ruff", "textDocument/codeLens", "command", lens("ruff", 1)))
+	pyright := newTestServer(t, "pyright", resolvable,
+		echoResolveHandler("pyright", "textDocument/codeLens", "command", lens("pyright", 2), lens("pyright", 3)))
+	h := newTestClientHandler(&Config{}, ruff, pyright)
+
+	var lenses []map[string]any
+	if err := json.Unmarshal(testCall(t, h, "textDocument/codeLens", map[string]any{"textDocument": map[string]any{"uri": "file:///a.py"}}), &lenses); err != nil {
+		t.Fatal(err)
+	}
+	var gotData []any
+	for _, lens := range lenses {
+		gotData = append(gotData, lens["data"])
+	}
+	wantData := []any{wrapServerData("ruff", "ruff data"), wrapServerData("pyright", "pyright data"), wrapServerData("pyright", "pyright data")}
+	if diff := cmp.Diff(wantData, gotData); diff != "" {
+		t.Fatalf("codeLens data mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name string
+		lens map[string]any
+		want map[string]any
+	}{
+		{
+			name: "originating server",
+			lens: lenses[2],
+			want: map[string]any{"range": lenses[2]["range"], "data": wrapServerData("pyright", "pyright data"), "command": "resolved by pyright"},
+		},
+		{
+			name: "server without resolveProvider",
+			lens: lenses[0],
+			want: lenses[0],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			if err := json.Unmarshal(testCall(t, h, "codeLens/resolve", tt.lens), &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("codeLens/resolve mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormattingHideOthers(t *testing.T) {
+	formatServer := func(name string, kvCaps map[string]any) *ServerConnection {
+		return newTestServer(t, name, kvCaps, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+			if r.Method == "initialize" {
+				return map[string]any{"capabilities": kvCaps}, nil
+			}
+			return []map[string]any{{"range": rng(0, 0, 0, 0), "newText": name}}, nil
+		})
+	}
+	tsls := formatServer("tsls", map[string]any{"documentFormattingProvider": true, "documentOnTypeFormattingProvider": map[string]any{"firstTriggerCharacter": ";"}})
+	prettier := formatServer("prettier", map[string]any{"documentFormattingProvider": true, "documentRangeFormattingProvider": true})
+	ruff := formatServer("ruff", map[string]any{"documentFormattingProvider": true})
+	cfg := &Config{Formatter: FormatterConfig{
+		Rules: []FormatterRule{
+			{Languages: []protocol.LanguageKind{"python"}, Server: "ruff"},
+			{Languages: []protocol.LanguageKind{"markdown"}, Server: "prettier"},
+		},
+		HideOthers: true,
+	}}
+	h := newTestClientHandler(cfg, tsls, prettier, ruff)
+
+	var initRes struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	if err := json.Unmarshal(testCall(t, h, "initialize", map[string]any{"capabilities": map[string]any{}}), &initRes); err != nil {
+		t.Fatal(err)
+	}
+	wantCaps := map[string]any{"documentFormattingProvider": true, "documentRangeFormattingProvider": true}
+	if diff := cmp.Diff(wantCaps, initRes.Capabilities); diff != "" {
+		t.Errorf("capabilities mismatch (-want +got):\n%s", diff)
+	}
+
+	for uri, languageID := range map[string]string{"file:///a.py": "python", "file:///a.md": "markdown", "file:///a.ts": "typescript"} {
+		testNotify(t, h, "textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": languageID, "version": 1, "text": ""},
+		})
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		uri    string
+		want   string
+	}{
+		{name: "matching rule", method: "textDocument/formatting", uri: "file:///a.py", want: `[{"newText":"ruff","range":{"end":{"character":0,"line":0},"start":{"character":0,"line":0}}}]`},
+		{name: "no matching rule", method: "textDocument/formatting", uri: "file:///a.ts", want: `[{"newText":"prettier","range":{"end":{"character":0,"line":0},"start":{"character":0,"line":0}}}]`},
+		{name: "unsupported by the rule server", method: "textDocument/rangeFormatting", uri: "file:///a.py", want: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]any{"textDocument": map[string]any{"uri": tt.uri}, "range": rng(0, 0, 0, 0), "options": map[string]any{}}
+			got := testCall(t, h, tt.method, params)
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("%s mismatch (-want +got):\n%s", tt.method, diff)
+			}
+		})
+	}
+}
+
+func TestFormattingPipeline(t *testing.T) {
+	type change struct {
+		Version int32
+		Text    string
+	}
+	var mu sync.Mutex
+	changes := map[string][]change{}
+	formatHandler := func(serverName, prefix string) jsonrpc2.HandlerFunc {
+		return func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+			switch r.Method {
+			case "textDocument/didOpen", "textDocument/didChange":
+				var params struct {
+					TextDocument struct {
+						Version int32  `json:"version"`
+						Text    string `json:"text"`
+					} `json:"textDocument"`
+					ContentChanges []struct {
+						Text string `json:"text"`
+					} `json:"contentChanges"`
+				}
+				if err := json.Unmarshal(r.Params, &params); err != nil {
+					return nil, err
+				}
+				text := params.TextDocument.Text
+				if len(params.ContentChanges) != 0 {
+					text = params.ContentChanges[0].Text
+				}
+				mu.Lock()
+				changes[serverName] = append(changes[serverName], change{params.TextDocument.Version, text})
+				mu.Unlock()
+				return nil, nil
+			case "textDocument/formatting":
+				return []map[string]any{{"range": rng(0, 0, 0, 0), "newText": prefix}}, nil
+			default:
+				return json.RawMessage("null"), nil
+			}
+		}
+	}
+	kvCaps := map[string]any{"documentFormattingProvider": true, "textDocumentSync": 1}
+	tsls := newTestServer(t, "tsls", kvCaps, formatHandler("tsls", "a"))
+	eslint := newTestServer(t, "eslint", kvCaps, formatHandler("eslint", "b"))
+	cfg := &Config{Formatter: FormatterConfig{
+		Rules: []FormatterRule{{Languages: []protocol.LanguageKind{"javascript"}, Pipeline: []string{"tsls", "eslint"}}},
+	}}
+	h := newTestClientHandler(cfg, tsls, eslint)
+
+	uri := "file:///a.js"
+	testNotify(t, h, "textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "javascript", "version": 1, "text": "x"},
+	})
+	edits := testCall(t, h, "textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}, "options": map[string]any{}})
+	if want := `[{"newText":"bax","range":{"end":{"character":1,"line":0},"start":{"character":0,"line":0}}}]`; string(edits) != want {
+		t.Errorf("formatting result = %s, want %s", edits, want)
+	}
+	testNotify(t, h, "textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": "y"}},
+	})
+
+	// wait for the notifications to be handled
+	for _, server := range []*ServerConnection{tsls, eslint} {
+		if err := server.Call(context.Background(), "test/sync", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// versions are strictly increasing, and the next change of the client follows the synthetic ones
+	want := map[string][]change{
+		"tsls":   {{1, "x"}, {4, "y"}},
+		"eslint": {{1, "x"}, {2, "ax"}, {3, "x"}, {4, "y"}},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff(want, changes); diff != "" {
+		t.Errorf("document changes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDocumentDiagnostic(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: "ruff1"}
+	ruff := newTestServer(t, "ruff", map[string]any{"diagnosticProvider": map[string]any{}}, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		var params struct {
+			PreviousResultID string `json:"previousResultId"`
+		}
+		if err := json.Unmarshal(r.Params, &params); err != nil {
+			return nil, err
+		}
+		if params.PreviousResultID == "r1" {
+			return map[string]any{"kind": "unchanged", "resultId": "r1"}, nil
+		}
+		return map[string]any{"kind": "full", "resultId": "r1", "items": []protocol.Diagnostic{diag}}, nil
+	})
+	h := newTestClientHandler(&Config{}, ruff)
+
+	didOpen := func() {
+		testNotify(t, h, "textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": "python", "version": 1, "text": ""},
+		})
+	}
+	full := documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindFull, ResultID: `{"ruff":"r1"}`, Items: []protocol.Diagnostic{diag}}
+	unchanged := documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindUnchanged, ResultID: `{"ruff":"r1"}`}
+
+	tests := []struct {
+		name   string
+		before func()
+		want   documentDiagnosticReport
+	}{
+		// the client keeps the result ID over restarts of lsmux
+		{name: "unchanged without cache", before: didOpen, want: full},
+		{name: "unchanged with cache", before: func() {}, want: unchanged},
+		{
+			name: "unchanged after reopen",
+			before: func() {
+				testNotify(t, h, "textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": uri}})
+				didOpen()
+			},
+			want: full,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.before()
+			res := testCall(t, h, "textDocument/diagnostic", map[string]any{
+				"textDocument":     map[string]any{"uri": uri},
+				"previousResultId": `{"ruff":"r1"}`,
+			})
+			var got documentDiagnosticReport
+			if err := json.Unmarshal(res, &got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("textDocument/diagnostic mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInitializeDiagnosticClientCapabilities(t *testing.T) {
+	clientCaps := map[string]any{
+		"textDocument": map[string]any{"hover": map[string]any{"contentFormat": []any{"markdown"}}},
+		"workspace":    map[string]any{"diagnostics": map[string]any{"refreshSupport": true}},
+	}
+
+	tests := []struct {
+		name string
+		mode DiagnosticMode
+		want map[string]any
+	}{
+		{name: "default", want: clientCaps},
+		{name: "pull mode", mode: DiagnosticModePull, want: clientCaps},
+		{
+			name: "push mode",
+			mode: DiagnosticModePush,
+			want: map[string]any{
+				"textDocument": map[string]any{
+					"hover":      map[string]any{"contentFormat": []any{"markdown"}},
+					"diagnostic": map[string]any{"dynamicRegistration": false},
+				},
+				"workspace": map[string]any{"diagnostics": map[string]any{"refreshSupport": true}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			ruff := newTestServer(t, "ruff", map[string]any{}, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+				var params struct {
+					Capabilities map[string]any `json:"capabilities"`
+				}
+				if err := json.Unmarshal(r.Params, &params); err != nil {
+					return nil, err
+				}
+				got = params.Capabilities
+				return map[string]any{"capabilities": map[string]any{}}, nil
+			})
+			h := newTestClientHandler(&Config{Diagnostics: DiagnosticsConfig{Mode: tt.mode}}, ruff)
+
+			testCall(t, h, "initialize", map[string]any{"capabilities": clientCaps})
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("client capabilities sent to the server mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCodeActionDiagnosticData(t *testing.T) {
+	// answers an action titled with the data of the diagnostics it received
+	handler := func(serverName string) jsonrpc2.HandlerFunc {
+		return func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+			var params protocol.CodeActionParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				return nil, err
+			}
+			var data []any
+			for _, diag := range params.Context.Diagnostics {
+				data = append(data, diag.Data)
+			}
+			b, err := json.Marshal(data)
+			if err != nil {
+				return nil, err
+			}
+			return []map[string]any{{"title": serverName + ": " + string(b)}}, nil
+		}
+	}
+	kvCaps := map[string]any{"codeActionProvider": true}
+	ruff := newTestServer(t, "ruff", kvCaps, handler("ruff"))
+	eslint := newTestServer(t, "eslint", kvCaps, handler("eslint"))
+
+	diag := func(serverName string) protocol.Diagnostic {
+		return protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: serverName, Data: wrapServerData(serverName, map[string]any{"fix": serverName})}
+	}
+	tests := []struct {
+		name    string
+		routing RoutingStrategy
+		diags   []protocol.Diagnostic
+		want    []string
+	}{
+		{name: "first", routing: RoutingStrategy{Strategy: RoutingFirst}, diags: []protocol.Diagnostic{diag("ruff"), diag("eslint")}, want: []string{`ruff: [{"fix":"ruff"}]`}},
+		{name: "priority", routing: RoutingStrategy{Strategy: RoutingFirst, Priority: []string{"eslint"}}, diags: []protocol.Diagnostic{diag("ruff"), diag("eslint")}, want: []string{`eslint: [{"fix":"eslint"}]`}},
+		{name: "first-non-empty", routing: RoutingStrategy{Strategy: RoutingFirstNonEmpty}, diags: []protocol.Diagnostic{diag("ruff"), diag("eslint")}, want: []string{`ruff: [{"fix":"ruff"}]`}},
+		{name: "all-merge", routing: RoutingStrategy{Strategy: RoutingAllMerge}, diags: []protocol.Diagnostic{diag("ruff"), diag("eslint")}, want: []string{`ruff: [{"fix":"ruff"}]`, `eslint: [{"fix":"eslint"}]`}},
+		{name: "first without diagnostics of the server", routing: RoutingStrategy{Strategy: RoutingFirst}, diags: []protocol.Diagnostic{diag("eslint")}, want: []string{`ruff: null`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Routing:     RoutingTable{"textDocument/codeAction": tt.routing},
+				Diagnostics: DiagnosticsConfig{ServerData: true},
+			}
+			h := newTestClientHandler(cfg, ruff, eslint)
+			params := protocol.CodeActionParams{
+				TextDocument: protocol.TextDocumentIdentifier{Uri: "file:///a.py"},
+				Range:        rng(0, 0, 0, 1),
+				Context:      protocol.CodeActionContext{Diagnostics: tt.diags},
+			}
+
+			var actions []protocol.CodeAction
+			if err := json.Unmarshal(testCall(t, h, "textDocument/codeAction", params), &actions); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, action := range actions {
+				got = append(got, action.Title)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("codeAction titles mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -6,13 +6,69 @@ import (
 	"log/slog"
 	"os"
 	"slices"
+	"time"
 
 	"github.com/goccy/go-yaml"
+	"github.com/myleshyson/lsprotocol-go/protocol"
 )
 
 type Config struct {
-	LogLevel slog.Level     `yaml:"logLevel"`
-	Servers  []ServerConfig `yaml:"servers"` // use slice to respect config order
+	LogLevel        slog.Level            `yaml:"logLevel"`
+	Servers         []ServerConfig        `yaml:"servers"` // use slice to respect config order
+	Routing         RoutingTable          `yaml:"routing"`
+	Hover           HoverConfig           `yaml:"hover"`
+	WorkspaceSymbol WorkspaceSymbolConfig `yaml:"workspaceSymbol"`
+	Formatter       FormatterConfig       `yaml:"formatter"`
+	Diagnostics     DiagnosticsConfig     `yaml:"diagnostics"`
+}
+
+type HoverConfig struct {
+	// Separator is inserted between hover contents from different servers.
+	// A horizontal rule is used for markdown and a blank line for plaintext if empty.
+	Separator string `yaml:"separator"`
+	// ServerHeader adds the server name before each hover content.
+	ServerHeader bool `yaml:"serverHeader"`
+}
+
+type WorkspaceSymbolConfig struct {
+	// MaxResultsPerServer limits the number of symbols from each server. Zero means unlimited.
+	MaxResultsPerServer int `yaml:"maxResultsPerServer"`
+}
+
+type DiagnosticsConfig struct {
+	// Mode unifies the diagnostic model presented to the client. The models of servers are used as is if empty.
+	Mode DiagnosticMode `yaml:"mode"`
+	// Dedup drops diagnostics of a document reported by multiple servers. Nothing is dropped if empty.
+	Dedup DiagnosticDedupPolicy `yaml:"dedup"`
+	// Priority lists servers whose diagnostics are kept on deduplication and ordered before the others.
+	Priority []string `yaml:"priority"`
+	// Debounce is the quiet period to coalesce diagnostics of a document published by servers into a single notification.
+	// Each update of the document restarts the period. Diagnostics are published immediately if zero.
+	Debounce time.Duration `yaml:"debounce"`
+	// ClearOnClose clears diagnostics of a document when the client closes it, for servers that keep them.
+	ClearOnClose bool `yaml:"clearOnClose"`
+	// ServerSource sets the server name to the source of diagnostics, or prefixes the source with it.
+	ServerSource DiagnosticServerSource `yaml:"serverSource"`
+	// ServerData stores the server name in the data of diagnostics, which routes code actions for them to the server.
+	ServerData bool `yaml:"serverData"`
+}
+
+func (c DiagnosticsConfig) validate(serverNames []string) error {
+	if c.Mode != "" && !slices.Contains(diagnosticModes, c.Mode) {
+		return fmt.Errorf("diagnostics.mode: unknown mode: %s", c.Mode)
+	}
+	if c.Dedup != "" && !slices.Contains(diagnosticDedupPolicies, c.Dedup) {
+		return fmt.Errorf("diagnostics.dedup: unknown policy: %s", c.Dedup)
+	}
+	if c.ServerSource != "" && !slices.Contains(diagnosticServerSources, c.ServerSource) {
+		return fmt.Errorf("diagnostics.serverSource: unknown value: %s", c.ServerSource)
+	}
+	for _, name := range c.Priority {
+		if !slices.Contains(serverNames, name) {
+			return fmt.Errorf("diagnostics.priority: server not found in config: %s", name)
+		}
+	}
+	return nil
 }
 
 type ServerConfig struct {
@@ -20,6 +76,15 @@ type ServerConfig struct {
 	Command               string         `yaml:"command"`
 	Args                  []string       `yaml:"args"`
 	InitializationOptions map[string]any `yaml:"initializationOptions"`
+	// Languages and FilePatterns select documents sent to the server. All documents are sent if both are empty.
+	Languages    []protocol.LanguageKind `yaml:"languages"`
+	FilePatterns []Glob                  `yaml:"filePatterns"`
+	// Lazy defers starting the server until the client opens a document matching Languages or FilePatterns.
+	Lazy bool `yaml:"lazy"`
+	// MaxRestarts limits the number of automatic restarts after the server exits unexpectedly. Zero disables restarts.
+	MaxRestarts int `yaml:"maxRestarts"`
+	// Diagnostics rewrites push and pull diagnostics of the server.
+	Diagnostics ServerDiagnosticsConfig `yaml:"diagnostics"`
 }
 
 func LoadConfigFile(fname string, serverNames []string) (*Config, error) {
@@ -46,11 +111,45 @@ func LoadConfig(r io.Reader, serverNames []string) (*Config, error) {
 			return nil, fmt.Errorf("servers[%d]: command is required", i)
 		}
 
+		if cfg.Servers[i].MaxRestarts < 0 {
+			return nil, fmt.Errorf("servers[%d]: maxRestarts must not be negative", i)
+		}
+
+		if cfg.Servers[i].Lazy && len(cfg.Servers[i].Languages) == 0 && len(cfg.Servers[i].FilePatterns) == 0 {
+			return nil, fmt.Errorf("servers[%d]: languages or filePatterns is required for lazy server", i)
+		}
+
+		if err := cfg.Servers[i].Diagnostics.validate(); err != nil {
+			return nil, fmt.Errorf("servers[%d]: %w", i, err)
+		}
+
 		if cfg.Servers[i].Name == "" {
 			cfg.Servers[i].Name = cfg.Servers[i].Command
 		}
 	}
 
+	if cfg.Diagnostics.Debounce < 0 {
+		return nil, fmt.Errorf("diagnostics.debounce must not be negative")
+	}
+
+	if cfg.WorkspaceSymbol.MaxResultsPerServer < 0 {
+		return nil, fmt.Errorf("workspaceSymbol.maxResultsPerServer must not be negative")
+	}
+
+	allServerNames := make([]string, len(cfg.Servers))
+	for i, s := range cfg.Servers {
+		allServerNames[i] = s.Name
+	}
+	if err := cfg.Routing.validate(allServerNames); err != nil {
+		return nil, err
+	}
+	if err := cfg.Formatter.validate(allServerNames); err != nil {
+		return nil, err
+	}
+	if err := cfg.Diagnostics.validate(allServerNames); err != nil {
+		return nil, err
+	}
+
 	if len(serverNames) == 0 {
 		return &cfg, nil
 	}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
 )
 
 func TestLoadConfig_Servers(t *testing.T) {
@@ -41,6 +42,50 @@ func TestLoadConfig_Servers(t *testing.T) {
 				{Name: "server1", Command: "cmd1"},
 			},
 		},
+		{
+			name:        "maxRestarts",
+			serverNames: nil,
+			data:        `servers: [{name: server1, command: cmd1, maxRestarts: 3}, {name: server2, command: cmd2, maxRestarts: 5}]`,
+			want: []ServerConfig{
+				{Name: "server1", Command: "cmd1", MaxRestarts: 3},
+				{Name: "server2", Command: "cmd2", MaxRestarts: 5},
+			},
+		},
+		{
+			name:        "document selectors",
+			serverNames: nil,
+			data:        `servers: [{name: server1, command: cmd1, languages: [vue], filePatterns: ["*.vue"]}]`,
+			want: []ServerConfig{
+				{
+					Name:         "server1",
+					Command:      "cmd1",
+					Languages:    []protocol.LanguageKind{"vue"},
+					FilePatterns: []Glob{MustCompileGlob("*.vue")},
+				},
+			},
+		},
+		{
+			name:        "diagnostics",
+			serverNames: nil,
+			data: `servers: [{name: server1, command: cmd1, diagnostics: {
+				exclude: [{codes: [F401], message: "^unused"}, {patterns: ["*.test.ts"]}],
+				severity: {warning: hint},
+				sourcePrefix: "server1: "}}]`,
+			want: []ServerConfig{
+				{
+					Name:    "server1",
+					Command: "cmd1",
+					Diagnostics: ServerDiagnosticsConfig{
+						Exclude: []DiagnosticFilter{
+							{Codes: []string{"F401"}, Message: MustCompileRegexp("^unused")},
+							{Patterns: []Glob{MustCompileGlob("*.test.ts")}},
+						},
+						Severity:     map[string]string{"warning": "hint"},
+						SourcePrefix: "server1: ",
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -55,6 +100,60 @@ func TestLoadConfig_Servers(t *testing.T) {
 	}
 }
 
+func TestLoadConfig_Routing(t *testing.T) {
+	data := `
+servers: [{name: ruff, command: ruff}, {name: pyright, command: pyright}]
+routing:
+  textDocument/hover: first-non-empty
+  textDocument/formatting: {priority: [ruff, pyright]}
+  textDocument/*: {strategy: all-merge, exclude: [ruff]}
+`
+	want := RoutingTable{
+		"textDocument/hover":      {Strategy: RoutingFirstNonEmpty},
+		"textDocument/formatting": {Priority: []string{"ruff", "pyright"}},
+		"textDocument/*":          {Strategy: RoutingAllMerge, Exclude: []string{"ruff"}},
+	}
+
+	cfg, err := LoadConfig(bytes.NewBufferString(data), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, cfg.Routing); diff != "" {
+		t.Errorf("cfg.Routing mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadConfig_Formatter(t *testing.T) {
+	data := `
+servers: [{name: tsls, command: tsls}, {name: eslint, command: eslint}]
+formatter:
+  hideOthers: true
+  rules:
+    - languages: [typescript, vue]
+      server: eslint
+    - patterns: ["**/*.ts"]
+      server: tsls
+    - languages: [javascript]
+      pipeline: [eslint, tsls]
+`
+	want := FormatterConfig{
+		HideOthers: true,
+		Rules: []FormatterRule{
+			{Languages: []protocol.LanguageKind{"typescript", "vue"}, Server: "eslint"},
+			{Patterns: []Glob{MustCompileGlob("**/*.ts")}, Server: "tsls"},
+			{Languages: []protocol.LanguageKind{"javascript"}, Pipeline: []string{"eslint", "tsls"}},
+		},
+	}
+
+	cfg, err := LoadConfig(bytes.NewBufferString(data), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, cfg.Formatter); diff != "" {
+		t.Errorf("cfg.Formatter mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestLoadConfig_Errors(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -73,6 +172,91 @@ func TestLoadConfig_Errors(t *testing.T) {
 			data:    `servers: [{name: server}]`,
 			wantErr: "servers[0]: command is required",
 		},
+		{
+			name:    "lazy without selector",
+			data:    `servers: [{name: server, command: cmd, lazy: true}]`,
+			wantErr: "servers[0]: languages or filePatterns is required for lazy server",
+		},
+		{
+			name:    "negative maxRestarts",
+			data:    `servers: [{name: server, command: cmd, maxRestarts: -1}]`,
+			wantErr: "servers[0]: maxRestarts must not be negative",
+		},
+		{
+			name:    "unknown routing strategy",
+			data:    `{servers: [{name: server, command: cmd}], routing: {textDocument/hover: random}}`,
+			wantErr: "routing[textDocument/hover]: unknown strategy: random",
+		},
+		{
+			name:    "unknown routing server",
+			data:    `{servers: [{name: server, command: cmd}], routing: {textDocument/hover: {exclude: [server2]}}}`,
+			wantErr: "routing[textDocument/hover]: server not found in config: server2",
+		},
+		{
+			name:    "invalid routing pattern",
+			data:    `{servers: [{name: server, command: cmd}], routing: {"textDocument/[": first}}`,
+			wantErr: "routing[textDocument/[]: invalid pattern",
+		},
+		{
+			name:    "formatter without selector",
+			data:    `{servers: [{name: server, command: cmd}], formatter: {rules: [{server: server}]}}`,
+			wantErr: "formatter.rules[0]: languages or patterns is required",
+		},
+		{
+			name:    "formatter with server and pipeline",
+			data:    `{servers: [{name: server, command: cmd}], formatter: {rules: [{languages: [python], server: server, pipeline: [server]}]}}`,
+			wantErr: "formatter.rules[0]: either server or pipeline is required",
+		},
+		{
+			name:    "unknown pipeline formatter",
+			data:    `{servers: [{name: server, command: cmd}], formatter: {rules: [{languages: [python], pipeline: [server, server2]}]}}`,
+			wantErr: "formatter.rules[0]: server not found in config: server2",
+		},
+		{
+			name:    "unknown formatter",
+			data:    `{servers: [{name: server, command: cmd}], formatter: {rules: [{languages: [python], server: server2}]}}`,
+			wantErr: "formatter.rules[0]: server not found in config: server2",
+		},
+		{
+			name:    "invalid formatter pattern",
+			data:    `{servers: [{name: server, command: cmd}], formatter: {rules: [{patterns: ["{a,b"], server: server}]}}`,
+			wantErr: "invalid glob pattern",
+		},
+		{
+			name:    "unknown diagnostic severity",
+			data:    `servers: [{name: server, command: cmd, diagnostics: {severity: {warning: note}}}]`,
+			wantErr: "servers[0]: diagnostics.severity: unknown severity: note",
+		},
+		{
+			name:    "invalid diagnostic message regexp",
+			data:    `servers: [{name: server, command: cmd, diagnostics: {exclude: [{message: "("}]}}]`,
+			wantErr: "invalid regexp",
+		},
+		{
+			name:    "unknown diagnostics dedup policy",
+			data:    `{servers: [{name: server, command: cmd}], diagnostics: {dedup: range}}`,
+			wantErr: "diagnostics.dedup: unknown policy: range",
+		},
+		{
+			name:    "unknown diagnostics priority server",
+			data:    `{servers: [{name: server, command: cmd}], diagnostics: {priority: [server2]}}`,
+			wantErr: "diagnostics.priority: server not found in config: server2",
+		},
+		{
+			name:    "negative diagnostics debounce",
+			data:    `{servers: [{name: server, command: cmd}], diagnostics: {debounce: -1s}}`,
+			wantErr: "diagnostics.debounce must not be negative",
+		},
+		{
+			name:    "unknown diagnostics server source",
+			data:    `{servers: [{name: server, command: cmd}], diagnostics: {serverSource: append}}`,
+			wantErr: "diagnostics.serverSource: unknown value: append",
+		},
+		{
+			name:    "unknown diagnostics mode",
+			data:    `{servers: [{name: server, command: cmd}], diagnostics: {mode: poll}}`,
+			wantErr: "diagnostics.mode: unknown mode: poll",
+		},
 	}
 
 	for _, tt := range tests {
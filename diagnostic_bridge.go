@@ -0,0 +1,149 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/sync/errgroup"
+)
+
+// diagnosticPullDelay is the delay to pull diagnostics after the last change of a document in push mode.
+const diagnosticPullDelay = 300 * time.Millisecond
+
+// pushedResultIDKey is the key of the result ID of push diagnostics in combined result IDs.
+const pushedResultIDKey = "lsmux/pushed"
+
+// pushedResultID returns the result ID of push diagnostics of the document in pull mode.
+func (h *ClientHandler) pushedResultID(uri protocol.DocumentUri) string {
+	return strconv.Itoa(h.diagRegistry.Revision(uri))
+}
+
+// bridgeDiagnosticCapabilities rewrites the diagnostic capabilities advertised to the client for the diagnostic mode.
+func (h *ClientHandler) bridgeDiagnosticCapabilities(caps map[string]any) {
+	switch h.cfg.Diagnostics.Mode {
+	case DiagnosticModePush:
+		delete(caps, "diagnosticProvider")
+	case DiagnosticModePull:
+		if _, ok := caps["diagnosticProvider"].(map[string]any); !ok {
+			caps["diagnosticProvider"] = map[string]any{
+				"interFileDependencies": false,
+				"workspaceDiagnostics":  false,
+			}
+		}
+	}
+}
+
+// bridgeDiagnosticClientCapabilities rewrites the client capabilities sent to servers for the diagnostic mode.
+// In push mode, servers are told that the client supports pull diagnostics, since lsmux pulls them instead.
+func (h *ClientHandler) bridgeDiagnosticClientCapabilities(kvParams map[string]any) {
+	if h.cfg.Diagnostics.Mode != DiagnosticModePush {
+		return
+	}
+
+	caps, ok := kvParams["capabilities"].(map[string]any)
+	if !ok {
+		caps = map[string]any{}
+		kvParams["capabilities"] = caps
+	}
+	textDocument, ok := caps["textDocument"].(map[string]any)
+	if !ok {
+		textDocument = map[string]any{}
+		caps["textDocument"] = textDocument
+	}
+	// registrations are not forwarded to the client in push mode
+	textDocument["diagnostic"] = map[string]any{"dynamicRegistration": false}
+}
+
+// scheduleDiagnosticPull pulls diagnostics of the document after changes settle in push mode.
+func (h *ClientHandler) scheduleDiagnosticPull(ctx context.Context, method string, doc Document) {
+	if h.cfg.Diagnostics.Mode != DiagnosticModePush {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if timer, ok := h.pullTimers[doc.URI]; ok {
+		timer.Stop()
+		delete(h.pullTimers, doc.URI)
+	}
+
+	switch protocol.MethodKind(method) {
+	case protocol.TextDocumentDidOpenMethod,
+		protocol.TextDocumentDidChangeMethod,
+		protocol.TextDocumentDidSaveMethod:
+	default:
+		return
+	}
+
+	// the pull must outlive the notification
+	ctx = context.WithoutCancel(ctx)
+	h.pullTimers[doc.URI] = time.AfterFunc(diagnosticPullDelay, func() {
+		h.mu.Lock()
+		delete(h.pullTimers, doc.URI)
+		h.mu.Unlock()
+
+		if err := h.pullDiagnostics(ctx, doc.URI); err != nil {
+			slog.WarnContext(ctx, "failed to pull diagnostics", "uri", doc.URI, "error", err)
+		}
+	})
+}
+
+// pullDiagnostics pulls diagnostics of the document from servers and publishes them to the client with push diagnostics.
+func (h *ClientHandler) pullDiagnostics(ctx context.Context, uri protocol.DocumentUri) error {
+	doc, found := h.documents.Get(uri)
+	if !found {
+		// closed while waiting
+		return nil
+	}
+
+	method := string(protocol.TextDocumentDiagnosticMethod)
+	servers := h.serverRegistry.Servers().FilterByReady().FilterBySupportedMethod(method).FilterByDocument(method, doc)
+	if len(servers) == 0 {
+		return nil
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, server := range servers {
+		g.Go(func() error {
+			params := map[string]any{"textDocument": protocol.TextDocumentIdentifier{Uri: uri}}
+			if id := h.diagReports.ResultID(uri, server.Name); id != "" {
+				params["previousResultId"] = id
+			}
+
+			res, err := h.callDocumentDiagnostic(gctx, server, uri, params)
+			if err != nil {
+				return err
+			}
+			if isEmptyResult(res) {
+				return nil
+			}
+
+			var report documentDiagnosticReport
+			if err := json.Unmarshal(res, &report); err != nil {
+				return err
+			}
+			// cached items are rewritten already
+			if report.Kind != protocol.DocumentDiagnosticReportKindUnchanged {
+				report.Items = server.Diagnostics.Apply(uri, report.Items)
+			}
+			items, ok := h.diagReports.Resolve(uri, server.Name, report)
+			if !ok {
+				// pull the full report next time
+				return nil
+			}
+			h.diagRegistry.UpdateDiagnostics(uri, server.Name, doc.Version, items)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	h.diagRegistry.Publish(ctx, uri)
+	return nil
+}
@@ -0,0 +1,161 @@
+package lsmux
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// ServerDiagnosticsConfig rewrites push and pull diagnostics of a server.
+type ServerDiagnosticsConfig struct {
+	// Exclude drops diagnostics matching any of the filters.
+	Exclude []DiagnosticFilter `yaml:"exclude"`
+	// Severity remaps severities by name, e.g. {warning: hint}.
+	Severity map[string]string `yaml:"severity"`
+	// SourcePrefix is prepended to the source of diagnostics, e.g. "eslint: ".
+	// Diagnostics without source get the prefix without trailing separators.
+	SourcePrefix string `yaml:"sourcePrefix"`
+}
+
+// DiagnosticFilter matches diagnostics. All specified fields must match.
+type DiagnosticFilter struct {
+	Codes   []string `yaml:"codes"`
+	Sources []string `yaml:"sources"`
+	Message Regexp   `yaml:"message"`
+	// Patterns match the path of the document.
+	Patterns []Glob `yaml:"patterns"`
+}
+
+var diagnosticSeverities = map[string]protocol.DiagnosticSeverity{
+	"error":       protocol.DiagnosticSeverityError,
+	"warning":     protocol.DiagnosticSeverityWarning,
+	"information": protocol.DiagnosticSeverityInformation,
+	"hint":        protocol.DiagnosticSeverityHint,
+}
+
+func (c ServerDiagnosticsConfig) validate() error {
+	for _, from := range slices.Sorted(maps.Keys(c.Severity)) {
+		for _, name := range []string{from, c.Severity[from]} {
+			if _, ok := diagnosticSeverities[name]; !ok {
+				return fmt.Errorf("diagnostics.severity: unknown severity: %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply returns the diagnostics of the document rewritten by the config.
+func (c ServerDiagnosticsConfig) Apply(uri protocol.DocumentUri, diags []protocol.Diagnostic) []protocol.Diagnostic {
+	if len(c.Exclude) == 0 && len(c.Severity) == 0 && c.SourcePrefix == "" {
+		return diags
+	}
+
+	res := []protocol.Diagnostic{}
+	for _, diag := range diags {
+		if slices.ContainsFunc(c.Exclude, func(f DiagnosticFilter) bool { return f.Match(uri, diag) }) {
+			continue
+		}
+
+		if diag.Severity != nil {
+			for from, to := range c.Severity {
+				if diagnosticSeverities[from] == *diag.Severity {
+					severity := diagnosticSeverities[to]
+					diag.Severity = &severity
+					break
+				}
+			}
+		}
+		switch {
+		case c.SourcePrefix == "" || strings.HasPrefix(diag.Source, c.SourcePrefix):
+		case diag.Source == "":
+			diag.Source = strings.TrimRight(c.SourcePrefix, " :/-")
+		default:
+			diag.Source = c.SourcePrefix + diag.Source
+		}
+		res = append(res, diag)
+	}
+	return res
+}
+
+func (f DiagnosticFilter) Match(uri protocol.DocumentUri, diag protocol.Diagnostic) bool {
+	if len(f.Codes) != 0 && !slices.Contains(f.Codes, diagnosticCode(diag)) {
+		return false
+	}
+	if len(f.Sources) != 0 && !slices.Contains(f.Sources, diag.Source) {
+		return false
+	}
+	if !f.Message.IsZero() && !f.Message.MatchString(diag.Message) {
+		return false
+	}
+	if len(f.Patterns) != 0 && !slices.ContainsFunc(f.Patterns, func(g Glob) bool { return g.MatchURI(uri) }) {
+		return false
+	}
+	return true
+}
+
+// diagnosticCode returns the code of the diagnostic as a string, or an empty string if it has no code.
+func diagnosticCode(diag protocol.Diagnostic) string {
+	if diag.Code == nil || diag.Code.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(diag.Code.Value)
+}
+
+// Regexp is a regular expression of the Go syntax.
+type Regexp struct {
+	re *regexp.Regexp
+}
+
+func CompileRegexp(expr string) (Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Regexp{}, fmt.Errorf("invalid regexp %q: %w", expr, err)
+	}
+	return Regexp{re: re}, nil
+}
+
+func MustCompileRegexp(expr string) Regexp {
+	r, err := CompileRegexp(expr)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (r Regexp) String() string {
+	if r.re == nil {
+		return ""
+	}
+	return r.re.String()
+}
+
+func (r Regexp) Equal(other Regexp) bool {
+	return r.String() == other.String()
+}
+
+// IsZero reports whether the expression is not specified.
+func (r Regexp) IsZero() bool {
+	return r.re == nil
+}
+
+func (r Regexp) MatchString(s string) bool {
+	return r.re != nil && r.re.MatchString(s)
+}
+
+func (r *Regexp) UnmarshalYAML(b []byte) error {
+	var expr string
+	if err := yaml.Unmarshal(b, &expr); err != nil {
+		return err
+	}
+	v, err := CompileRegexp(expr)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
@@ -0,0 +1,94 @@
+package lsmux
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestServerDiagnosticsConfig_Apply(t *testing.T) {
+	diag := func(code any, source string, severity protocol.DiagnosticSeverity, message string) protocol.Diagnostic {
+		d := protocol.Diagnostic{Range: rng(0, 0, 0, 1), Source: source, Message: message}
+		if code != nil {
+			d.Code = &protocol.Or2[int32, string]{Value: code}
+		}
+		if severity != 0 {
+			d.Severity = &severity
+		}
+		return d
+	}
+
+	tests := []struct {
+		name  string
+		cfg   ServerDiagnosticsConfig
+		uri   protocol.DocumentUri
+		diags []protocol.Diagnostic
+		want  []protocol.Diagnostic
+	}{
+		{
+			name:  "empty config",
+			uri:   "file:///a.py",
+			diags: []protocol.Diagnostic{diag("F401", "ruff", protocol.DiagnosticSeverityWarning, "unused import")},
+			want:  []protocol.Diagnostic{diag("F401", "ruff", protocol.DiagnosticSeverityWarning, "unused import")},
+		},
+		{
+			name: "exclude by code and message",
+			cfg: ServerDiagnosticsConfig{
+				Exclude: []DiagnosticFilter{{Codes: []string{"F401", "2304"}, Message: MustCompileRegexp("^unused")}},
+			},
+			uri: "file:///a.py",
+			diags: []protocol.Diagnostic{
+				diag("F401", "ruff", protocol.DiagnosticSeverityWarning, "unused import"),
+				diag("F401", "ruff", protocol.DiagnosticSeverityWarning, "redefinition"),
+				diag(int32(2304), "ts", protocol.DiagnosticSeverityError, "unused name"),
+				diag(nil, "ruff", protocol.DiagnosticSeverityError, "unused name"),
+			},
+			want: []protocol.Diagnostic{
+				diag("F401", "ruff", protocol.DiagnosticSeverityWarning, "redefinition"),
+				diag(nil, "ruff", protocol.DiagnosticSeverityError, "unused name"),
+			},
+		},
+		{
+			name: "exclude by source and pattern",
+			cfg: ServerDiagnosticsConfig{
+				Exclude: []DiagnosticFilter{{Sources: []string{"Pyright"}, Patterns: []Glob{MustCompileGlob("**/tests/**")}}},
+			},
+			uri: "file:///src/tests/a.py",
+			diags: []protocol.Diagnostic{
+				diag(nil, "Pyright", protocol.DiagnosticSeverityError, "undefined name"),
+				diag(nil, "ruff", protocol.DiagnosticSeverityError, "undefined name"),
+			},
+			want: []protocol.Diagnostic{
+				diag(nil, "ruff", protocol.DiagnosticSeverityError, "undefined name"),
+			},
+		},
+		{
+			name: "remap severity and prefix source",
+			cfg: ServerDiagnosticsConfig{
+				Severity:     map[string]string{"warning": "hint", "hint": "information"},
+				SourcePrefix: "eslint: ",
+			},
+			uri: "file:///a.ts",
+			diags: []protocol.Diagnostic{
+				diag("no-unused-vars", "", protocol.DiagnosticSeverityWarning, "unused"),
+				diag("eqeqeq", "eslint: core", protocol.DiagnosticSeverityHint, "use ==="),
+				diag("semi", "core", 0, "missing semicolon"),
+			},
+			want: []protocol.Diagnostic{
+				diag("no-unused-vars", "eslint", protocol.DiagnosticSeverityHint, "unused"),
+				diag("eqeqeq", "eslint: core", protocol.DiagnosticSeverityInformation, "use ==="),
+				diag("semi", "eslint: core", 0, "missing semicolon"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cfg.Apply(tt.uri, tt.diags)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Apply() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
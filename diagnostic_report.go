@@ -0,0 +1,354 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// documentDiagnosticReport is a full or unchanged document diagnostic report of pull diagnostics.
+type documentDiagnosticReport struct {
+	Kind             protocol.DocumentDiagnosticReportKind             `json:"kind"`
+	ResultID         string                                            `json:"resultId,omitempty"`
+	Items            []protocol.Diagnostic                             `json:"items"`
+	RelatedDocuments map[protocol.DocumentUri]documentDiagnosticReport `json:"relatedDocuments,omitempty"`
+}
+
+func (r documentDiagnosticReport) MarshalJSON() ([]byte, error) {
+	type alias documentDiagnosticReport
+	if r.Kind != protocol.DocumentDiagnosticReportKindUnchanged {
+		return json.Marshal(alias(r))
+	}
+
+	// unchanged reports have no items
+	return json.Marshal(struct {
+		Kind             protocol.DocumentDiagnosticReportKind             `json:"kind"`
+		ResultID         string                                            `json:"resultId"`
+		RelatedDocuments map[protocol.DocumentUri]documentDiagnosticReport `json:"relatedDocuments,omitempty"`
+	}{r.Kind, r.ResultID, r.RelatedDocuments})
+}
+
+// encodeResultID combines result IDs of servers into a single result ID for the client.
+func encodeResultID(ids map[string]string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(ids)
+	return string(b)
+}
+
+// decodeResultID splits the result ID created by encodeResultID into result IDs of servers.
+func decodeResultID(id string) map[string]string {
+	var ids map[string]string
+	if err := json.Unmarshal([]byte(id), &ids); err != nil {
+		return map[string]string{}
+	}
+	return ids
+}
+
+// DiagnosticReportCache keeps the last diagnostic report of each server to resolve unchanged reports.
+type DiagnosticReportCache struct {
+	mu sync.Mutex
+	// document uri -> server name -> last full report
+	reports map[protocol.DocumentUri]map[string]documentDiagnosticReport
+}
+
+func NewDiagnosticReportCache() *DiagnosticReportCache {
+	return &DiagnosticReportCache{
+		reports: make(map[protocol.DocumentUri]map[string]documentDiagnosticReport),
+	}
+}
+
+// Resolve returns the diagnostics of the report and caches full reports.
+// The cached diagnostics are returned for unchanged reports, and false is returned if they are not cached.
+func (c *DiagnosticReportCache) Resolve(uri protocol.DocumentUri, serverName string, report documentDiagnosticReport) ([]protocol.Diagnostic, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if report.Kind == protocol.DocumentDiagnosticReportKindUnchanged {
+		cached, ok := c.reports[uri][serverName]
+		if !ok || cached.ResultID != report.ResultID {
+			delete(c.reports[uri], serverName)
+			return nil, false
+		}
+		return cached.Items, true
+	}
+
+	if _, ok := c.reports[uri]; !ok {
+		c.reports[uri] = make(map[string]documentDiagnosticReport)
+	}
+	c.reports[uri][serverName] = documentDiagnosticReport{Kind: report.Kind, ResultID: report.ResultID, Items: report.Items}
+	return report.Items, true
+}
+
+// Remove removes the cached reports of the document.
+func (c *DiagnosticReportCache) Remove(uri protocol.DocumentUri) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.reports, uri)
+}
+
+// RemoveServer removes the cached reports of the server.
+func (c *DiagnosticReportCache) RemoveServer(serverName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for uri, reports := range c.reports {
+		delete(reports, serverName)
+		if len(reports) == 0 {
+			delete(c.reports, uri)
+		}
+	}
+}
+
+// ResultID returns the result ID of the cached report of the server.
+func (c *DiagnosticReportCache) ResultID(uri protocol.DocumentUri, serverName string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reports[uri][serverName].ResultID
+}
+
+// Diagnostics returns the cached diagnostics of the document by server.
+func (c *DiagnosticReportCache) Diagnostics(uri protocol.DocumentUri) map[string][]protocol.Diagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	diags := map[string][]protocol.Diagnostic{}
+	for name, report := range c.reports[uri] {
+		diags[name] = report.Items
+	}
+	return diags
+}
+
+// Merged returns the cached diagnostics of all servers for the URI and their result IDs.
+func (c *DiagnosticReportCache) Merged(uri protocol.DocumentUri) ([]protocol.Diagnostic, map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := []protocol.Diagnostic{}
+	ids := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(c.reports[uri])) {
+		report := c.reports[uri][name]
+		items = append(items, report.Items...)
+		if report.ResultID != "" {
+			ids[name] = report.ResultID
+		}
+	}
+	return items, ids
+}
+
+// diagnosticReportAccumulator accumulates reports of a document from servers.
+type diagnosticReportAccumulator struct {
+	ids     map[string]string
+	items   []protocol.Diagnostic
+	changed bool
+}
+
+func (a *diagnosticReportAccumulator) add(cache *DiagnosticReportCache, uri protocol.DocumentUri, serverName string, diagCfg ServerDiagnosticsConfig, report documentDiagnosticReport) {
+	// cached items are rewritten already
+	if report.Kind != protocol.DocumentDiagnosticReportKindUnchanged {
+		report.Items = diagCfg.Apply(uri, report.Items)
+	}
+	items, ok := cache.Resolve(uri, serverName, report)
+	if !ok {
+		// drop the result ID to receive the full report next time
+		a.changed = true
+		return
+	}
+
+	if report.ResultID != "" {
+		a.ids[serverName] = report.ResultID
+	}
+	a.items = append(a.items, items...)
+	if report.Kind != protocol.DocumentDiagnosticReportKindUnchanged {
+		a.changed = true
+	}
+}
+
+// DocumentDiagnosticMerger merges document diagnostic reports from multiple servers.
+type DocumentDiagnosticMerger struct {
+	cache       *DiagnosticReportCache
+	diagConfigs map[string]ServerDiagnosticsConfig
+	uri         protocol.DocumentUri
+	doc         diagnosticReportAccumulator
+	related     map[protocol.DocumentUri]*diagnosticReportAccumulator
+}
+
+// NewDocumentDiagnosticMerger creates a merger of document diagnostic reports.
+// Items of full reports are rewritten by diagConfigs of their servers.
+func NewDocumentDiagnosticMerger(cache *DiagnosticReportCache, diagConfigs map[string]ServerDiagnosticsConfig, uri protocol.DocumentUri) *DocumentDiagnosticMerger {
+	return &DocumentDiagnosticMerger{
+		cache:       cache,
+		diagConfigs: diagConfigs,
+		uri:         uri,
+		doc:         diagnosticReportAccumulator{ids: map[string]string{}},
+		related:     make(map[protocol.DocumentUri]*diagnosticReportAccumulator),
+	}
+}
+
+// Add adds a document diagnostic report of the server.
+func (m *DocumentDiagnosticMerger) Add(serverName string, res json.RawMessage) error {
+	if isEmptyResult(res) {
+		return nil
+	}
+
+	var report documentDiagnosticReport
+	if err := json.Unmarshal(res, &report); err != nil {
+		return err
+	}
+
+	m.doc.add(m.cache, m.uri, serverName, m.diagConfigs[serverName], report)
+	for uri, related := range report.RelatedDocuments {
+		acc, ok := m.related[uri]
+		if !ok {
+			acc = &diagnosticReportAccumulator{ids: map[string]string{}}
+			m.related[uri] = acc
+		}
+		acc.add(m.cache, uri, serverName, m.diagConfigs[serverName], related)
+	}
+	return nil
+}
+
+// AddPushed adds diagnostics published by servers. resultID must change whenever they change.
+func (m *DocumentDiagnosticMerger) AddPushed(resultID string, items []protocol.Diagnostic) {
+	m.doc.ids[pushedResultIDKey] = resultID
+	m.doc.items = append(m.doc.items, items...)
+}
+
+// Result returns the merged report.
+// It is unchanged only if all servers report unchanged for previousResultID.
+func (m *DocumentDiagnosticMerger) Result(previousResultID string) documentDiagnosticReport {
+	res := documentDiagnosticReport{
+		Kind:     protocol.DocumentDiagnosticReportKindFull,
+		ResultID: encodeResultID(m.doc.ids),
+		Items:    m.doc.items,
+	}
+	if !m.doc.changed && previousResultID != "" && res.ResultID == previousResultID {
+		res.Kind = protocol.DocumentDiagnosticReportKindUnchanged
+		res.Items = nil
+	}
+	if res.Items == nil && res.Kind == protocol.DocumentDiagnosticReportKindFull {
+		res.Items = []protocol.Diagnostic{}
+	}
+
+	// related documents are always reported in full, since the client has no previous result of them
+	for uri, acc := range m.related {
+		if res.RelatedDocuments == nil {
+			res.RelatedDocuments = make(map[protocol.DocumentUri]documentDiagnosticReport)
+		}
+		res.RelatedDocuments[uri] = documentDiagnosticReport{
+			Kind:     protocol.DocumentDiagnosticReportKindFull,
+			ResultID: encodeResultID(acc.ids),
+			Items:    append([]protocol.Diagnostic{}, acc.items...),
+		}
+	}
+	return res
+}
+
+// workspaceDocumentDiagnosticReport is a full or unchanged document diagnostic report in workspace diagnostics.
+type workspaceDocumentDiagnosticReport struct {
+	URI      protocol.DocumentUri                  `json:"uri"`
+	Version  *int32                                `json:"version"`
+	Kind     protocol.DocumentDiagnosticReportKind `json:"kind"`
+	ResultID string                                `json:"resultId,omitempty"`
+	Items    []protocol.Diagnostic                 `json:"items"`
+}
+
+func (r workspaceDocumentDiagnosticReport) MarshalJSON() ([]byte, error) {
+	type alias workspaceDocumentDiagnosticReport
+	if r.Kind != protocol.DocumentDiagnosticReportKindUnchanged {
+		return json.Marshal(alias(r))
+	}
+
+	// unchanged reports have no items
+	return json.Marshal(struct {
+		URI      protocol.DocumentUri                  `json:"uri"`
+		Version  *int32                                `json:"version"`
+		Kind     protocol.DocumentDiagnosticReportKind `json:"kind"`
+		ResultID string                                `json:"resultId"`
+	}{r.URI, r.Version, r.Kind, r.ResultID})
+}
+
+// WorkspaceDiagnosticMerger merges workspace diagnostic reports from multiple servers by URI.
+// Reports of a URI are merged with the cached reports of the other servers, since the client replaces all diagnostics of the URI.
+type WorkspaceDiagnosticMerger struct {
+	mu                sync.Mutex
+	cache             *DiagnosticReportCache
+	diagConfigs       map[string]ServerDiagnosticsConfig
+	previousResultIDs map[protocol.DocumentUri]string
+	reports           map[protocol.DocumentUri]*workspaceDocumentDiagnosticReport
+}
+
+// NewWorkspaceDiagnosticMerger creates a merger of workspace diagnostic reports.
+// Items of full reports are rewritten by diagConfigs of their servers.
+func NewWorkspaceDiagnosticMerger(cache *DiagnosticReportCache, diagConfigs map[string]ServerDiagnosticsConfig, previousResultIDs map[protocol.DocumentUri]string) *WorkspaceDiagnosticMerger {
+	return &WorkspaceDiagnosticMerger{
+		cache:             cache,
+		diagConfigs:       diagConfigs,
+		previousResultIDs: previousResultIDs,
+		reports:           make(map[protocol.DocumentUri]*workspaceDocumentDiagnosticReport),
+	}
+}
+
+// Add adds a workspace diagnostic report or a partial result of the server, and returns merged reports of the URIs in it.
+func (m *WorkspaceDiagnosticMerger) Add(serverName string, res json.RawMessage) ([]workspaceDocumentDiagnosticReport, error) {
+	if isEmptyResult(res) {
+		return nil, nil
+	}
+
+	var report struct {
+		Items []workspaceDocumentDiagnosticReport `json:"items"`
+	}
+	if err := json.Unmarshal(res, &report); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var merged []workspaceDocumentDiagnosticReport
+	for _, item := range report.Items {
+		docReport := documentDiagnosticReport{Kind: item.Kind, ResultID: item.ResultID, Items: item.Items}
+		if item.Kind != protocol.DocumentDiagnosticReportKindUnchanged {
+			docReport.Items = m.diagConfigs[serverName].Apply(item.URI, item.Items)
+		}
+		_, ok := m.cache.Resolve(item.URI, serverName, docReport)
+		changed := !ok || item.Kind != protocol.DocumentDiagnosticReportKindUnchanged
+
+		items, ids := m.cache.Merged(item.URI)
+		r := workspaceDocumentDiagnosticReport{
+			URI:      item.URI,
+			Version:  item.Version,
+			Kind:     protocol.DocumentDiagnosticReportKindFull,
+			ResultID: encodeResultID(ids),
+			Items:    items,
+		}
+		if prev, found := m.reports[item.URI]; found && prev.Kind == protocol.DocumentDiagnosticReportKindFull {
+			changed = true
+		}
+		if !changed && r.ResultID != "" && r.ResultID == m.previousResultIDs[item.URI] {
+			r.Kind = protocol.DocumentDiagnosticReportKindUnchanged
+			r.Items = nil
+		}
+		m.reports[item.URI] = &r
+		merged = append(merged, r)
+	}
+	return merged, nil
+}
+
+// Result returns merged reports of all URIs reported by servers.
+func (m *WorkspaceDiagnosticMerger) Result() []workspaceDocumentDiagnosticReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res := []workspaceDocumentDiagnosticReport{}
+	for _, uri := range slices.Sorted(maps.Keys(m.reports)) {
+		res = append(res, *m.reports[uri])
+	}
+	return res
+}
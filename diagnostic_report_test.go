@@ -0,0 +1,302 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestDocumentDiagnosticMerger(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := func(message string) protocol.Diagnostic {
+		return protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: message}
+	}
+	cache := NewDiagnosticReportCache()
+
+	type step struct {
+		name             string
+		previousResultID string
+		results          map[string]string
+		want             documentDiagnosticReport
+	}
+	steps := []step{
+		{
+			name: "full reports",
+			results: map[string]string{
+				"ruff":    `{"kind": "full", "resultId": "r1", "items": [{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "message": "ruff1"}]}`,
+				"pyright": `{"kind": "full", "resultId": "p1", "items": [{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "message": "pyright1"}]}`,
+			},
+			want: documentDiagnosticReport{
+				Kind:     protocol.DocumentDiagnosticReportKindFull,
+				ResultID: `{"pyright":"p1","ruff":"r1"}`,
+				Items:    []protocol.Diagnostic{diag("ruff1"), diag("pyright1")},
+			},
+		},
+		{
+			name:             "all unchanged",
+			previousResultID: `{"pyright":"p1","ruff":"r1"}`,
+			results: map[string]string{
+				"ruff":    `{"kind": "unchanged", "resultId": "r1"}`,
+				"pyright": `{"kind": "unchanged", "resultId": "p1"}`,
+			},
+			want: documentDiagnosticReport{
+				Kind:     protocol.DocumentDiagnosticReportKindUnchanged,
+				ResultID: `{"pyright":"p1","ruff":"r1"}`,
+			},
+		},
+		{
+			name:             "partially unchanged",
+			previousResultID: `{"pyright":"p1","ruff":"r1"}`,
+			results: map[string]string{
+				"ruff":    `{"kind": "unchanged", "resultId": "r1"}`,
+				"pyright": `{"kind": "full", "resultId": "p2", "items": []}`,
+			},
+			want: documentDiagnosticReport{
+				Kind:     protocol.DocumentDiagnosticReportKindFull,
+				ResultID: `{"pyright":"p2","ruff":"r1"}`,
+				Items:    []protocol.Diagnostic{diag("ruff1")},
+			},
+		},
+		{
+			name:             "unknown unchanged report",
+			previousResultID: `{"pyright":"p2","ruff":"r1"}`,
+			results: map[string]string{
+				"ruff":    `{"kind": "unchanged", "resultId": "r0"}`,
+				"pyright": `{"kind": "unchanged", "resultId": "p2"}`,
+			},
+			want: documentDiagnosticReport{
+				Kind:     protocol.DocumentDiagnosticReportKindFull,
+				ResultID: `{"pyright":"p2"}`,
+				Items:    []protocol.Diagnostic{},
+			},
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			m := NewDocumentDiagnosticMerger(cache, nil, uri)
+			for _, name := range []string{"ruff", "pyright"} {
+				if err := m.Add(name, json.RawMessage(step.results[name])); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if diff := cmp.Diff(step.want, m.Result(step.previousResultID)); diff != "" {
+				t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDocumentDiagnosticMerger_AddPushed(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := func(message string) protocol.Diagnostic {
+		return protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: message}
+	}
+	cache := NewDiagnosticReportCache()
+
+	merge := func(previousResultID string, pushedResultID string) documentDiagnosticReport {
+		m := NewDocumentDiagnosticMerger(cache, nil, uri)
+		if err := m.Add("ruff", json.RawMessage(`{"kind": "unchanged", "resultId": "r1"}`)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m.AddPushed(pushedResultID, []protocol.Diagnostic{diag("pyright1")})
+		return m.Result(previousResultID)
+	}
+
+	cache.Resolve(uri, "ruff", documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindFull, ResultID: "r1", Items: []protocol.Diagnostic{diag("ruff1")}})
+	want := documentDiagnosticReport{
+		Kind:     protocol.DocumentDiagnosticReportKindFull,
+		ResultID: `{"lsmux/pushed":"1","ruff":"r1"}`,
+		Items:    []protocol.Diagnostic{diag("ruff1"), diag("pyright1")},
+	}
+	if diff := cmp.Diff(want, merge("", "1")); diff != "" {
+		t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+	}
+
+	want = documentDiagnosticReport{
+		Kind:     protocol.DocumentDiagnosticReportKindUnchanged,
+		ResultID: `{"lsmux/pushed":"1","ruff":"r1"}`,
+	}
+	if diff := cmp.Diff(want, merge(`{"lsmux/pushed":"1","ruff":"r1"}`, "1")); diff != "" {
+		t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+	}
+
+	// push diagnostics are updated
+	want = documentDiagnosticReport{
+		Kind:     protocol.DocumentDiagnosticReportKindFull,
+		ResultID: `{"lsmux/pushed":"2","ruff":"r1"}`,
+		Items:    []protocol.Diagnostic{diag("ruff1"), diag("pyright1")},
+	}
+	if diff := cmp.Diff(want, merge(`{"lsmux/pushed":"1","ruff":"r1"}`, "2")); diff != "" {
+		t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDocumentDiagnosticReport_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		report documentDiagnosticReport
+		want   string
+	}{
+		{
+			name:   "full",
+			report: documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindFull, Items: []protocol.Diagnostic{}},
+			want:   `{"kind":"full","items":[]}`,
+		},
+		{
+			name:   "unchanged",
+			report: documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindUnchanged, ResultID: "1"},
+			want:   `{"kind":"unchanged","resultId":"1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.report)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json.Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkspaceDiagnosticMerger(t *testing.T) {
+	cache := NewDiagnosticReportCache()
+	diag := func(message string) protocol.Diagnostic {
+		return protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: message}
+	}
+	version := int32(3)
+
+	m := NewWorkspaceDiagnosticMerger(cache, nil, map[protocol.DocumentUri]string{})
+	if _, err := m.Add("ruff", json.RawMessage(`{"items": [
+		{"uri": "file:///a.py", "version": 3, "kind": "full", "resultId": "r1", "items": [{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "message": "ruff1"}]},
+		{"uri": "file:///b.py", "version": null, "kind": "full", "resultId": "r2", "items": []}
+	]}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a partial result merged with the cached report of ruff
+	got, err := m.Add("pyright", json.RawMessage(`{"items": [
+		{"uri": "file:///a.py", "version": 3, "kind": "full", "resultId": "p1", "items": [{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "message": "pyright1"}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantA := workspaceDocumentDiagnosticReport{
+		URI:      "file:///a.py",
+		Version:  &version,
+		Kind:     protocol.DocumentDiagnosticReportKindFull,
+		ResultID: `{"pyright":"p1","ruff":"r1"}`,
+		Items:    []protocol.Diagnostic{diag("pyright1"), diag("ruff1")},
+	}
+	if diff := cmp.Diff([]workspaceDocumentDiagnosticReport{wantA}, got); diff != "" {
+		t.Errorf("Add() mismatch (-want +got):\n%s", diff)
+	}
+
+	wantB := workspaceDocumentDiagnosticReport{
+		URI:      "file:///b.py",
+		Kind:     protocol.DocumentDiagnosticReportKindFull,
+		ResultID: `{"ruff":"r2"}`,
+		Items:    []protocol.Diagnostic{},
+	}
+	if diff := cmp.Diff([]workspaceDocumentDiagnosticReport{wantA, wantB}, m.Result()); diff != "" {
+		t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+	}
+
+	// the next request with the previous result IDs
+	m = NewWorkspaceDiagnosticMerger(cache, nil, map[protocol.DocumentUri]string{
+		"file:///a.py": `{"pyright":"p1","ruff":"r1"}`,
+	})
+	for name, res := range map[string]string{
+		"ruff":    `{"items": [{"uri": "file:///a.py", "version": 3, "kind": "unchanged", "resultId": "r1"}]}`,
+		"pyright": `{"items": [{"uri": "file:///a.py", "version": 3, "kind": "unchanged", "resultId": "p1"}]}`,
+	} {
+		if _, err := m.Add(name, json.RawMessage(res)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	want := []workspaceDocumentDiagnosticReport{{
+		URI:      "file:///a.py",
+		Version:  &version,
+		Kind:     protocol.DocumentDiagnosticReportKindUnchanged,
+		ResultID: `{"pyright":"p1","ruff":"r1"}`,
+	}}
+	if diff := cmp.Diff(want, m.Result()); diff != "" {
+		t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiagnosticMerger_DiagnosticsConfig(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := func(severity protocol.DiagnosticSeverity, message string) protocol.Diagnostic {
+		return protocol.Diagnostic{Range: rng(0, 0, 0, 1), Severity: &severity, Message: message}
+	}
+	// remapping twice would turn warnings into errors
+	diagConfigs := map[string]ServerDiagnosticsConfig{
+		"ruff": {
+			Exclude:  []DiagnosticFilter{{Message: MustCompileRegexp("^unused")}},
+			Severity: map[string]string{"warning": "hint", "hint": "error"},
+		},
+	}
+	ruffFull := `{"kind": "full", "resultId": "r1", "items": [
+		{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "severity": 2, "message": "undefined name"},
+		{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "severity": 2, "message": "unused import"}
+	]}`
+	pyrightFull := `{"kind": "full", "resultId": "p1", "items": [
+		{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 1}}, "severity": 2, "message": "pyright1"}
+	]}`
+	want := []protocol.Diagnostic{
+		diag(protocol.DiagnosticSeverityHint, "undefined name"),
+		diag(protocol.DiagnosticSeverityWarning, "pyright1"),
+	}
+
+	t.Run("document", func(t *testing.T) {
+		cache := NewDiagnosticReportCache()
+		for _, ruffRes := range []string{ruffFull, `{"kind": "unchanged", "resultId": "r1"}`} {
+			m := NewDocumentDiagnosticMerger(cache, diagConfigs, uri)
+			for name, res := range map[string]string{"ruff": ruffRes, "pyright": pyrightFull} {
+				if err := m.Add(name, json.RawMessage(res)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got := m.Result("").Items
+			slices.SortFunc(got, func(a, b protocol.Diagnostic) int { return strings.Compare(b.Message, a.Message) })
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Result() items mismatch (-want +got):\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("workspace", func(t *testing.T) {
+		cache := NewDiagnosticReportCache()
+		workspaceReport := func(res string) json.RawMessage {
+			var report map[string]any
+			if err := json.Unmarshal([]byte(res), &report); err != nil {
+				t.Fatal(err)
+			}
+			report["uri"] = uri
+			report["version"] = nil
+			b, _ := json.Marshal(map[string]any{"items": []any{report}})
+			return b
+		}
+		for _, ruffRes := range []string{ruffFull, `{"kind": "unchanged", "resultId": "r1"}`} {
+			m := NewWorkspaceDiagnosticMerger(cache, diagConfigs, map[protocol.DocumentUri]string{})
+			for _, name := range []string{"ruff", "pyright"} {
+				res := map[string]string{"ruff": ruffRes, "pyright": pyrightFull}[name]
+				if _, err := m.Add(name, workspaceReport(res)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			got := m.Result()[0].Items
+			slices.SortFunc(got, func(a, b protocol.Diagnostic) int { return strings.Compare(b.Message, a.Message) })
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Result() items mismatch (-want +got):\n%s", diff)
+			}
+		}
+	})
+}
@@ -1,43 +1,443 @@
 package lsmux
 
 import (
+	"cmp"
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/exp/jsonrpc2"
 )
 
+type DiagnosticMode string
+
+const (
+	// DiagnosticModePush publishes diagnostics pulled from pull servers to the client.
+	DiagnosticModePush DiagnosticMode = "push"
+	// DiagnosticModePull answers pull diagnostics of the client including diagnostics published by push servers.
+	DiagnosticModePull DiagnosticMode = "pull"
+)
+
+var diagnosticModes = []DiagnosticMode{DiagnosticModePush, DiagnosticModePull}
+
+type DiagnosticDedupPolicy string
+
+const (
+	// DiagnosticDedupRangeCode treats diagnostics with the same range and code as duplicates.
+	DiagnosticDedupRangeCode DiagnosticDedupPolicy = "range-code"
+	// DiagnosticDedupRangeMessage treats diagnostics with the same range and similar messages as duplicates.
+	DiagnosticDedupRangeMessage DiagnosticDedupPolicy = "range-message"
+)
+
+var diagnosticDedupPolicies = []DiagnosticDedupPolicy{DiagnosticDedupRangeCode, DiagnosticDedupRangeMessage}
+
+type DiagnosticServerSource string
+
+const (
+	// DiagnosticServerSourceSet replaces the source of diagnostics with the server name.
+	DiagnosticServerSourceSet DiagnosticServerSource = "set"
+	// DiagnosticServerSourcePrefix prefixes the source of diagnostics with the server name, e.g. "ruff: Ruff".
+	DiagnosticServerSourcePrefix DiagnosticServerSource = "prefix"
+)
+
+var diagnosticServerSources = []DiagnosticServerSource{DiagnosticServerSourceSet, DiagnosticServerSourcePrefix}
+
 type DiagnosticRegistry struct {
+	cfg DiagnosticsConfig
+	// server names in the order of diagnostics
+	serverOrder []string
+
 	mu sync.Mutex
 	// document uri -> server name -> list of diags
 	allDiags map[protocol.DocumentUri]map[string][]protocol.Diagnostic
+	// document uri -> server name -> document version of diags
+	diagVersions map[protocol.DocumentUri]map[string]int32
+	// document uri -> revision of the last update, which is forgotten with the diagnostics of the document
+	revisions map[protocol.DocumentUri]int
+	seq       int
+	// document uri -> latest document version of diagnostics
+	versions map[protocol.DocumentUri]int32
+	// document uri -> pending publication
+	timers  map[protocol.DocumentUri]*time.Timer
+	publish func(ctx context.Context, params protocol.PublishDiagnosticsParams) error
+	refresh func(ctx context.Context) error
+	// whether the client supports workspace/diagnostic/refresh
+	refreshSupport bool
+	// last pull diagnostic reports of servers
+	reports *DiagnosticReportCache
 }
 
-func NewDiagnosticRegistry() *DiagnosticRegistry {
+// NewDiagnosticRegistry creates a registry of diagnostics.
+// Diagnostics are ordered by servers in cfg.Priority, and then by serverNames.
+func NewDiagnosticRegistry(cfg DiagnosticsConfig, serverNames []string) *DiagnosticRegistry {
+	serverOrder := slices.Clone(cfg.Priority)
+	for _, name := range serverNames {
+		if !slices.Contains(serverOrder, name) {
+			serverOrder = append(serverOrder, name)
+		}
+	}
+
 	return &DiagnosticRegistry{
-		allDiags: make(map[protocol.DocumentUri]map[string][]protocol.Diagnostic),
+		cfg:          cfg,
+		serverOrder:  serverOrder,
+		allDiags:     make(map[protocol.DocumentUri]map[string][]protocol.Diagnostic),
+		diagVersions: make(map[protocol.DocumentUri]map[string]int32),
+		revisions:    make(map[protocol.DocumentUri]int),
+		versions:     make(map[protocol.DocumentUri]int32),
+		timers:       make(map[protocol.DocumentUri]*time.Timer),
+		publish: func(context.Context, protocol.PublishDiagnosticsParams) error {
+			return nil
+		},
+		refresh: func(context.Context) error {
+			return nil
+		},
+		reports: NewDiagnosticReportCache(),
+	}
+}
+
+// Reports returns the cache of pull diagnostic reports, whose entries of a server are removed with RemoveServer.
+func (r *DiagnosticRegistry) Reports() *DiagnosticReportCache {
+	return r.reports
+}
+
+// SetClientConn sets the connection to the client, which is used to publish and refresh diagnostics.
+func (r *DiagnosticRegistry) SetClientConn(conn *jsonrpc2.Connection) {
+	r.publish = func(ctx context.Context, params protocol.PublishDiagnosticsParams) error {
+		return conn.Notify(ctx, string(protocol.TextDocumentPublishDiagnosticsMethod), params)
+	}
+	r.refresh = func(ctx context.Context) error {
+		return conn.Call(ctx, string(protocol.WorkspaceDiagnosticRefreshMethod), nil).Await(ctx, nil)
+	}
+}
+
+// SetRefreshSupport sets whether the client supports workspace/diagnostic/refresh.
+func (r *DiagnosticRegistry) SetRefreshSupport(supported bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refreshSupport = supported
+}
+
+// Refresh asks the client to pull diagnostics again.
+// Nothing is sent unless the mode is pull and the client supports workspace/diagnostic/refresh.
+func (r *DiagnosticRegistry) Refresh(ctx context.Context) error {
+	r.mu.Lock()
+	supported := r.refreshSupport
+	r.mu.Unlock()
+
+	if r.cfg.Mode != DiagnosticModePull || !supported {
+		return nil
 	}
+	return r.refresh(ctx)
 }
 
-func (r *DiagnosticRegistry) UpdateDiagnostics(uri protocol.DocumentUri, serverName string, diags []protocol.Diagnostic) {
+// UpdateDiagnostics updates the diagnostics of the server for the document version.
+// It returns false if the version is older than the diagnostics of other servers. Zero version means unknown.
+// Diagnostics of other servers for older versions are dropped, since they do not match the document anymore.
+func (r *DiagnosticRegistry) UpdateDiagnostics(uri protocol.DocumentUri, serverName string, version int32, diags []protocol.Diagnostic) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	if version != 0 {
+		if version < r.versions[uri] {
+			return false
+		}
+		r.versions[uri] = version
+		for name, v := range r.diagVersions[uri] {
+			if v != 0 && v < version {
+				r.deleteLocked(uri, name)
+			}
+		}
+	}
+
+	r.seq++
+	r.revisions[uri] = r.seq
+
+	// forget documents without diagnostics
+	if len(diags) == 0 {
+		r.deleteLocked(uri, serverName)
+		return true
+	}
 	if _, ok := r.allDiags[uri]; !ok {
 		r.allDiags[uri] = make(map[string][]protocol.Diagnostic)
+		r.diagVersions[uri] = make(map[string]int32)
+	}
+	r.allDiags[uri][serverName] = r.tagDiagnostics(serverName, diags)
+	r.diagVersions[uri][serverName] = version
+	return true
+}
+
+// tagDiagnostics returns copies of the diagnostics tagged with the server name.
+func (r *DiagnosticRegistry) tagDiagnostics(serverName string, diags []protocol.Diagnostic) []protocol.Diagnostic {
+	if r.cfg.ServerSource == "" && !r.cfg.ServerData {
+		return diags
+	}
+
+	tagged := make([]protocol.Diagnostic, len(diags))
+	for i, diag := range diags {
+		switch {
+		case r.cfg.ServerSource == "":
+		case r.cfg.ServerSource == DiagnosticServerSourceSet || diag.Source == "":
+			diag.Source = serverName
+		case r.cfg.ServerSource == DiagnosticServerSourcePrefix:
+			diag.Source = serverName + ": " + diag.Source
+		}
+		if r.cfg.ServerData {
+			diag.Data = wrapServerData(serverName, diag.Data)
+		}
+		tagged[i] = diag
+	}
+	return tagged
+}
+
+// ReportingServers returns the servers that reported the diagnostic for the document by push or pull diagnostics in the server order.
+// Diagnostics are compared by range, message and code, since clients may not send back the other fields.
+func (r *DiagnosticRegistry) ReportingServers(uri protocol.DocumentUri, diag protocol.Diagnostic) []string {
+	serverDiags := r.reports.Diagnostics(uri)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name, diags := range r.allDiags[uri] {
+		serverDiags[name] = append(slices.Clip(serverDiags[name]), diags...)
 	}
 
-	r.allDiags[uri][serverName] = diags
+	var servers []string
+	for _, name := range slices.SortedFunc(maps.Keys(serverDiags), r.compareServers) {
+		if slices.ContainsFunc(serverDiags[name], func(d protocol.Diagnostic) bool {
+			return d.Range == diag.Range && d.Message == diag.Message && diagnosticCode(d) == diagnosticCode(diag)
+		}) {
+			servers = append(servers, name)
+		}
+	}
+	return servers
+}
+
+// splitCodeActionDiagnostics splits diagnostics of a code action context by the servers that reported them.
+// Diagnostics of unknown servers are keyed by an empty name. It also reports whether all diagnostics are tagged with their servers.
+func splitCodeActionDiagnostics(diagRegistry *DiagnosticRegistry, uri protocol.DocumentUri, diags []protocol.Diagnostic) (map[string][]protocol.Diagnostic, bool) {
+	serverDiags := map[string][]protocol.Diagnostic{}
+	allTagged := len(diags) != 0
+	for _, diag := range diags {
+		if serverName, originalData, err := unwrapServerData(diag.Data); err == nil {
+			diag.Data = originalData
+			serverDiags[serverName] = append(serverDiags[serverName], diag)
+			continue
+		}
+		allTagged = false
+
+		serverNames := diagRegistry.ReportingServers(uri, diag)
+		if len(serverNames) == 0 {
+			serverNames = []string{""}
+		}
+		for _, name := range serverNames {
+			serverDiags[name] = append(serverDiags[name], diag)
+		}
+	}
+	return serverDiags, allTagged
 }
 
+// RemoveServer removes the diagnostics and the cached pull reports of the server, and returns the documents that had the diagnostics.
+func (r *DiagnosticRegistry) RemoveServer(serverName string) []protocol.DocumentUri {
+	r.reports.RemoveServer(serverName)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var uris []protocol.DocumentUri
+	for _, uri := range slices.Sorted(maps.Keys(r.allDiags)) {
+		if _, ok := r.allDiags[uri][serverName]; !ok {
+			continue
+		}
+		r.seq++
+		r.revisions[uri] = r.seq
+		r.deleteLocked(uri, serverName)
+		uris = append(uris, uri)
+	}
+	return uris
+}
+
+// Clear removes the diagnostics of all servers for the document.
+func (r *DiagnosticRegistry) Clear(uri protocol.DocumentUri) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.allDiags, uri)
+	delete(r.diagVersions, uri)
+	delete(r.revisions, uri)
+	delete(r.versions, uri)
+}
+
+func (r *DiagnosticRegistry) deleteLocked(uri protocol.DocumentUri, serverName string) {
+	delete(r.allDiags[uri], serverName)
+	delete(r.diagVersions[uri], serverName)
+	if len(r.allDiags[uri]) == 0 {
+		delete(r.allDiags, uri)
+		delete(r.diagVersions, uri)
+		delete(r.revisions, uri)
+	}
+}
+
+// ResetVersion forgets the document version of the diagnostics, since versions start over when the document is reopened.
+func (r *DiagnosticRegistry) ResetVersion(uri protocol.DocumentUri) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.versions, uri)
+}
+
+// Publish publishes the diagnostics of the document to the client after the debounce window.
+// The window is restarted by each call, so that updates of the document are published by a single notification after they settle.
+// Nothing is published in pull mode, since the client pulls diagnostics.
+func (r *DiagnosticRegistry) Publish(ctx context.Context, uri protocol.DocumentUri) {
+	if r.cfg.Mode == DiagnosticModePull {
+		return
+	}
+	if r.cfg.Debounce <= 0 {
+		r.publishNow(ctx, uri)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if timer, ok := r.timers[uri]; ok && timer.Stop() {
+		timer.Reset(r.cfg.Debounce)
+		return
+	}
+	// the publication must outlive the notification from the server
+	ctx = context.WithoutCancel(ctx)
+	var timer *time.Timer
+	timer = time.AfterFunc(r.cfg.Debounce, func() {
+		r.mu.Lock()
+		// the timer may be replaced after it fired
+		if r.timers[uri] == timer {
+			delete(r.timers, uri)
+		}
+		r.mu.Unlock()
+
+		r.publishNow(ctx, uri)
+	})
+	r.timers[uri] = timer
+}
+
+func (r *DiagnosticRegistry) publishNow(ctx context.Context, uri protocol.DocumentUri) {
+	params := protocol.PublishDiagnosticsParams{
+		Uri:         uri,
+		Diagnostics: r.GetDiagnostics(uri),
+	}
+	r.mu.Lock()
+	params.Version = r.versions[uri]
+	r.mu.Unlock()
+	if params.Diagnostics == nil {
+		params.Diagnostics = []protocol.Diagnostic{}
+	}
+
+	if err := r.publish(ctx, params); err != nil {
+		slog.WarnContext(ctx, "failed to publish diagnostics", "uri", uri, "error", err)
+	}
+}
+
+// Revision returns the revision of the diagnostics of the document, which can be used as a result ID of pull diagnostics.
+// It changes whenever the diagnostics are updated. It is zero if the document has no diagnostics.
+func (r *DiagnosticRegistry) Revision(uri protocol.DocumentUri) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.revisions[uri]
+}
+
+// GetDiagnostics returns the diagnostics of all servers deduplicated and sorted by position and server order.
 func (r *DiagnosticRegistry) GetDiagnostics(uri protocol.DocumentUri) []protocol.Diagnostic {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	var combined []protocol.Diagnostic
-	if serverDiags, ok := r.allDiags[uri]; ok {
-		for _, diags := range serverDiags {
-			combined = append(combined, diags...)
+	type entry struct {
+		diag   protocol.Diagnostic
+		server string
+		rank   int
+	}
+
+	var entries []entry
+	// duplicates have the same range
+	byRange := map[protocol.Range][]entry{}
+	for _, name := range slices.SortedFunc(maps.Keys(r.allDiags[uri]), r.compareServers) {
+		rank := r.serverRank(name)
+		for _, diag := range r.allDiags[uri][name] {
+			e := entry{diag, name, rank}
+			if r.cfg.Dedup != "" {
+				// diagnostics of preceding servers are kept
+				if slices.ContainsFunc(byRange[diag.Range], func(e entry) bool { return e.server != name && isDuplicateDiagnostic(r.cfg.Dedup, e.diag, diag) }) {
+					continue
+				}
+				byRange[diag.Range] = append(byRange[diag.Range], e)
+			}
+			entries = append(entries, e)
 		}
 	}
+
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return cmp.Or(
+			cmp.Compare(a.diag.Range.Start.Line, b.diag.Range.Start.Line),
+			cmp.Compare(a.diag.Range.Start.Character, b.diag.Range.Start.Character),
+			cmp.Compare(a.rank, b.rank),
+		)
+	})
+
+	var combined []protocol.Diagnostic
+	for _, e := range entries {
+		combined = append(combined, e.diag)
+	}
 	return combined
 }
+
+// serverRank returns the position of the server in the server order. Unknown servers follow the known ones.
+func (r *DiagnosticRegistry) serverRank(name string) int {
+	if i := slices.Index(r.serverOrder, name); i != -1 {
+		return i
+	}
+	return len(r.serverOrder)
+}
+
+func (r *DiagnosticRegistry) compareServers(a, b string) int {
+	return cmp.Or(cmp.Compare(r.serverRank(a), r.serverRank(b)), strings.Compare(a, b))
+}
+
+// isDuplicateDiagnostic reports whether diagnostics from different servers are duplicates by the policy.
+func isDuplicateDiagnostic(policy DiagnosticDedupPolicy, a, b protocol.Diagnostic) bool {
+	if a.Range != b.Range {
+		return false
+	}
+
+	switch policy {
+	case DiagnosticDedupRangeCode:
+		code := diagnosticCode(a)
+		return code != "" && code == diagnosticCode(b)
+	case DiagnosticDedupRangeMessage:
+		return isSimilarMessage(a.Message, b.Message)
+	default:
+		return false
+	}
+}
+
+// isSimilarMessage reports whether messages are the same ignoring case, punctuation and spaces, or one contains the other.
+func isSimilarMessage(a, b string) bool {
+	a, b = normalizeMessage(a), normalizeMessage(b)
+	if a == "" || b == "" {
+		return false
+	}
+	return strings.Contains(a, b) || strings.Contains(b, a)
+}
+
+func normalizeMessage(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	return strings.Join(fields, " ")
+}
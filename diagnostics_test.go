@@ -0,0 +1,513 @@
+package lsmux
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestDiagnosticRegistry_GetDiagnostics(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := func(line uint32, code string, message string) protocol.Diagnostic {
+		return protocol.Diagnostic{
+			Range:   rng(line, 0, line, 1),
+			Code:    &protocol.Or2[int32, string]{Value: code},
+			Message: message,
+		}
+	}
+	serverDiags := map[string][]protocol.Diagnostic{
+		"ruff": {
+			diag(2, "F821", "Undefined name `foo`"),
+			diag(0, "F401", "`os` imported but unused"),
+		},
+		"pyright": {
+			diag(0, "reportUnusedImport", "Import \"os\" is not accessed"),
+			diag(2, "reportUndefinedVariable", "\"foo\" is not defined"),
+			diag(2, "F821", "undefined name foo"),
+		},
+		"mypy": {
+			diag(1, "name-defined", "Name \"foo\" is not defined"),
+		},
+	}
+
+	tests := []struct {
+		name string
+		cfg  DiagnosticsConfig
+		want []protocol.Diagnostic
+	}{
+		{
+			name: "sort by position and server order",
+			want: []protocol.Diagnostic{
+				diag(0, "reportUnusedImport", "Import \"os\" is not accessed"),
+				diag(0, "F401", "`os` imported but unused"),
+				diag(1, "name-defined", "Name \"foo\" is not defined"),
+				diag(2, "reportUndefinedVariable", "\"foo\" is not defined"),
+				diag(2, "F821", "undefined name foo"),
+				diag(2, "F821", "Undefined name `foo`"),
+			},
+		},
+		{
+			name: "priority",
+			cfg:  DiagnosticsConfig{Priority: []string{"ruff"}},
+			want: []protocol.Diagnostic{
+				diag(0, "F401", "`os` imported but unused"),
+				diag(0, "reportUnusedImport", "Import \"os\" is not accessed"),
+				diag(1, "name-defined", "Name \"foo\" is not defined"),
+				diag(2, "F821", "Undefined name `foo`"),
+				diag(2, "reportUndefinedVariable", "\"foo\" is not defined"),
+				diag(2, "F821", "undefined name foo"),
+			},
+		},
+		{
+			name: "dedup by range and code",
+			cfg:  DiagnosticsConfig{Dedup: DiagnosticDedupRangeCode, Priority: []string{"ruff"}},
+			want: []protocol.Diagnostic{
+				diag(0, "F401", "`os` imported but unused"),
+				diag(0, "reportUnusedImport", "Import \"os\" is not accessed"),
+				diag(1, "name-defined", "Name \"foo\" is not defined"),
+				diag(2, "F821", "Undefined name `foo`"),
+				diag(2, "reportUndefinedVariable", "\"foo\" is not defined"),
+			},
+		},
+		{
+			name: "dedup by range and message",
+			cfg:  DiagnosticsConfig{Dedup: DiagnosticDedupRangeMessage},
+			want: []protocol.Diagnostic{
+				diag(0, "reportUnusedImport", "Import \"os\" is not accessed"),
+				diag(0, "F401", "`os` imported but unused"),
+				diag(1, "name-defined", "Name \"foo\" is not defined"),
+				diag(2, "reportUndefinedVariable", "\"foo\" is not defined"),
+				diag(2, "F821", "undefined name foo"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewDiagnosticRegistry(tt.cfg, []string{"pyright", "mypy", "ruff"})
+			for name, diags := range serverDiags {
+				r.UpdateDiagnostics(uri, name, 0, diags)
+			}
+			if diff := cmp.Diff(tt.want, r.GetDiagnostics(uri)); diff != "" {
+				t.Errorf("GetDiagnostics() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiagnosticRegistry_UpdateDiagnostics(t *testing.T) {
+	const uri = "file:///a.py"
+	diags := func(message string) []protocol.Diagnostic {
+		return []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: message}}
+	}
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff", "pyright"})
+
+	steps := []struct {
+		server  string
+		version int32
+		message string
+		want    bool
+	}{
+		{"ruff", 2, "ruff2", true},
+		{"pyright", 1, "pyright1", false},
+		{"pyright", 2, "pyright2", true},
+		// drops pyright2 of the old version
+		{"ruff", 3, "ruff3", true},
+		{"mypy", 0, "mypy?", true},
+		// keeps mypy? of the unknown version
+		{"ruff", 4, "ruff4", true},
+	}
+	for _, step := range steps {
+		if got := r.UpdateDiagnostics(uri, step.server, step.version, diags(step.message)); got != step.want {
+			t.Errorf("UpdateDiagnostics(%s, %d) = %v, want %v", step.server, step.version, got, step.want)
+		}
+	}
+	want := slices.Concat(diags("ruff4"), diags("mypy?"))
+	if diff := cmp.Diff(want, r.GetDiagnostics(uri)); diff != "" {
+		t.Errorf("GetDiagnostics() mismatch (-want +got):\n%s", diff)
+	}
+
+	// versions start over on reopen
+	r.ResetVersion(uri)
+	if !r.UpdateDiagnostics(uri, "pyright", 1, diags("pyright1")) {
+		t.Error("UpdateDiagnostics() after ResetVersion = false, want true")
+	}
+}
+
+func TestDiagnosticRegistry_Tag(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := func(source string, data any) protocol.Diagnostic {
+		return protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: "unused", Source: source, Data: data}
+	}
+
+	tests := []struct {
+		name string
+		cfg  DiagnosticsConfig
+		want []protocol.Diagnostic
+	}{
+		{
+			name: "no tags",
+			want: []protocol.Diagnostic{diag("Ruff", "fix"), diag("", nil)},
+		},
+		{
+			name: "set source",
+			cfg:  DiagnosticsConfig{ServerSource: DiagnosticServerSourceSet},
+			want: []protocol.Diagnostic{diag("ruff", "fix"), diag("ruff", nil)},
+		},
+		{
+			name: "prefix source",
+			cfg:  DiagnosticsConfig{ServerSource: DiagnosticServerSourcePrefix},
+			want: []protocol.Diagnostic{diag("ruff: Ruff", "fix"), diag("ruff", nil)},
+		},
+		{
+			name: "server data",
+			cfg:  DiagnosticsConfig{ServerData: true},
+			want: []protocol.Diagnostic{
+				diag("Ruff", wrapServerData("ruff", "fix")),
+				diag("", wrapServerData("ruff", nil)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewDiagnosticRegistry(tt.cfg, []string{"ruff"})
+			diags := []protocol.Diagnostic{diag("Ruff", "fix"), diag("", nil)}
+			r.UpdateDiagnostics(uri, "ruff", 0, diags)
+			if diff := cmp.Diff(tt.want, r.GetDiagnostics(uri)); diff != "" {
+				t.Errorf("GetDiagnostics() mismatch (-want +got):\n%s", diff)
+			}
+			if diags[0].Source != "Ruff" || diags[0].Data != "fix" {
+				t.Errorf("UpdateDiagnostics() modified the argument: %v", diags[0])
+			}
+		})
+	}
+}
+
+func TestSplitCodeActionDiagnostics(t *testing.T) {
+	const uri = "file:///a.py"
+	diag := func(code string, message string, data any) protocol.Diagnostic {
+		return protocol.Diagnostic{
+			Range:   rng(0, 0, 0, 1),
+			Code:    &protocol.Or2[int32, string]{Value: code},
+			Message: message,
+			Data:    data,
+		}
+	}
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff", "pyright"})
+	r.UpdateDiagnostics(uri, "ruff", 0, []protocol.Diagnostic{diag("F401", "unused import", "fix"), diag("E501", "line too long", nil)})
+	r.UpdateDiagnostics(uri, "pyright", 0, []protocol.Diagnostic{diag("reportUnusedImport", "unused import", nil), diag("E501", "line too long", nil)})
+
+	tests := []struct {
+		name          string
+		diags         []protocol.Diagnostic
+		want          map[string][]protocol.Diagnostic
+		wantAllTagged bool
+	}{
+		{
+			name:  "registry",
+			diags: []protocol.Diagnostic{diag("F401", "unused import", "fix"), diag("reportUnusedImport", "unused import", nil), diag("E501", "line too long", nil), diag("X", "unknown", nil)},
+			want: map[string][]protocol.Diagnostic{
+				"ruff":    {diag("F401", "unused import", "fix"), diag("E501", "line too long", nil)},
+				"pyright": {diag("reportUnusedImport", "unused import", nil), diag("E501", "line too long", nil)},
+				"":        {diag("X", "unknown", nil)},
+			},
+		},
+		{
+			name:  "tagged",
+			diags: []protocol.Diagnostic{diag("F401", "unused import", wrapServerData("ruff", "fix")), diag("X", "unknown", wrapServerData("mypy", nil))},
+			want: map[string][]protocol.Diagnostic{
+				"ruff": {diag("F401", "unused import", "fix")},
+				"mypy": {diag("X", "unknown", nil)},
+			},
+			wantAllTagged: true,
+		},
+		{
+			name:  "partially tagged",
+			diags: []protocol.Diagnostic{diag("F401", "unused import", wrapServerData("ruff", "fix")), diag("reportUnusedImport", "unused import", nil)},
+			want: map[string][]protocol.Diagnostic{
+				"ruff":    {diag("F401", "unused import", "fix")},
+				"pyright": {diag("reportUnusedImport", "unused import", nil)},
+			},
+		},
+		{
+			name:  "no diagnostics",
+			diags: nil,
+			want:  map[string][]protocol.Diagnostic{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotAllTagged := splitCodeActionDiagnostics(r, uri, tt.diags)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("splitCodeActionDiagnostics() mismatch (-want +got):\n%s", diff)
+			}
+			if gotAllTagged != tt.wantAllTagged {
+				t.Errorf("splitCodeActionDiagnostics() allTagged = %v, want %v", gotAllTagged, tt.wantAllTagged)
+			}
+		})
+	}
+}
+
+func TestDiagnosticRegistry_ReportingServers(t *testing.T) {
+	const uri = "file:///a.py"
+	unused := protocol.Diagnostic{Range: rng(0, 0, 0, 1), Message: "unused"}
+	undefined := protocol.Diagnostic{Range: rng(1, 0, 1, 1), Message: "undefined"}
+
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff", "pyright", "mypy"})
+	r.UpdateDiagnostics(uri, "mypy", 0, []protocol.Diagnostic{unused})
+	r.Reports().Resolve(uri, "pyright", documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindFull, ResultID: "pyright1", Items: []protocol.Diagnostic{unused, undefined}})
+	r.Reports().Resolve(uri, "ruff", documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindFull, ResultID: "ruff1"})
+
+	tests := []struct {
+		name string
+		diag protocol.Diagnostic
+		want []string
+	}{
+		{name: "push and pull", diag: unused, want: []string{"pyright", "mypy"}},
+		{name: "pull only", diag: undefined, want: []string{"pyright"}},
+		{name: "unknown", diag: protocol.Diagnostic{Range: rng(2, 0, 2, 1), Message: "unknown"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, r.ReportingServers(uri, tt.diag)); diff != "" {
+				t.Errorf("ReportingServers() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiagnosticRegistry_RemoveServer(t *testing.T) {
+	diags := func(message string) []protocol.Diagnostic {
+		return []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: message}}
+	}
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff", "pyright"})
+	r.UpdateDiagnostics("file:///b.py", "ruff", 0, diags("ruff-b"))
+	r.UpdateDiagnostics("file:///b.py", "pyright", 0, diags("pyright-b"))
+	r.UpdateDiagnostics("file:///a.py", "ruff", 0, diags("ruff-a"))
+	r.UpdateDiagnostics("file:///c.py", "pyright", 0, diags("pyright-c"))
+	revision := r.Revision("file:///a.py")
+
+	want := []protocol.DocumentUri{"file:///a.py", "file:///b.py"}
+	if diff := cmp.Diff(want, r.RemoveServer("ruff")); diff != "" {
+		t.Errorf("RemoveServer() mismatch (-want +got):\n%s", diff)
+	}
+	if got := r.GetDiagnostics("file:///a.py"); got != nil {
+		t.Errorf("GetDiagnostics(a.py) = %v, want nil", got)
+	}
+	if diff := cmp.Diff(diags("pyright-b"), r.GetDiagnostics("file:///b.py")); diff != "" {
+		t.Errorf("GetDiagnostics(b.py) mismatch (-want +got):\n%s", diff)
+	}
+	if r.Revision("file:///a.py") == revision {
+		t.Error("Revision(a.py) is not changed by RemoveServer")
+	}
+	if got := r.RemoveServer("ruff"); got != nil {
+		t.Errorf("RemoveServer() again = %v, want nil", got)
+	}
+}
+
+func TestDiagnosticRegistry_RemoveServerReports(t *testing.T) {
+	const uri = "file:///a.py"
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff", "pyright"})
+	for _, name := range []string{"ruff", "pyright"} {
+		r.Reports().Resolve(uri, name, documentDiagnosticReport{Kind: protocol.DocumentDiagnosticReportKindFull, ResultID: name + "1"})
+	}
+
+	r.RemoveServer("ruff")
+	if got := r.Reports().ResultID(uri, "ruff"); got != "" {
+		t.Errorf("ResultID(ruff) = %q, want empty", got)
+	}
+	if got := r.Reports().ResultID(uri, "pyright"); got != "pyright1" {
+		t.Errorf("ResultID(pyright) = %q, want %q", got, "pyright1")
+	}
+}
+
+func TestDiagnosticRegistry_Revision(t *testing.T) {
+	const uri = "file:///a.py"
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff", "pyright"})
+
+	r.UpdateDiagnostics(uri, "ruff", 1, []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "ruff1"}})
+	revision := r.Revision(uri)
+	if revision == 0 {
+		t.Error("Revision() = 0 for a document with diagnostics")
+	}
+	r.UpdateDiagnostics(uri, "pyright", 1, nil)
+	if r.Revision(uri) == revision {
+		t.Error("Revision() is not changed by UpdateDiagnostics")
+	}
+
+	// closed documents without diagnostics must not be kept
+	r.UpdateDiagnostics(uri, "ruff", 2, nil)
+	if got := r.Revision(uri); got != 0 {
+		t.Errorf("Revision() = %d, want 0 for a document without diagnostics", got)
+	}
+	if len(r.revisions) != 0 {
+		t.Errorf("revisions = %v, want empty", r.revisions)
+	}
+}
+
+func TestDiagnosticRegistry_Clear(t *testing.T) {
+	const uri = "file:///a.py"
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff"})
+	r.UpdateDiagnostics(uri, "ruff", 3, []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "ruff3"}})
+	revision := r.Revision(uri)
+
+	r.Clear(uri)
+	if got := r.GetDiagnostics(uri); got != nil {
+		t.Errorf("GetDiagnostics() = %v, want nil", got)
+	}
+	if r.Revision(uri) == revision {
+		t.Error("Revision() is not changed by Clear")
+	}
+	// the reopened document starts over its version
+	if !r.UpdateDiagnostics(uri, "ruff", 1, nil) {
+		t.Error("UpdateDiagnostics() after Clear = false, want true")
+	}
+}
+
+func TestDiagnosticRegistry_Publish(t *testing.T) {
+	const uri = "file:///a.py"
+	r := NewDiagnosticRegistry(DiagnosticsConfig{Debounce: 20 * time.Millisecond}, []string{"ruff", "pyright"})
+	published := make(chan protocol.PublishDiagnosticsParams, 10)
+	r.publish = func(_ context.Context, params protocol.PublishDiagnosticsParams) error {
+		published <- params
+		return nil
+	}
+
+	ctx := context.Background()
+	r.UpdateDiagnostics(uri, "ruff", 2, []protocol.Diagnostic{{Range: rng(1, 0, 1, 1), Message: "ruff2"}})
+	r.Publish(ctx, uri)
+	r.UpdateDiagnostics(uri, "pyright", 2, []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "pyright2"}})
+	r.Publish(ctx, uri)
+
+	want := protocol.PublishDiagnosticsParams{
+		Uri:     uri,
+		Version: 2,
+		Diagnostics: []protocol.Diagnostic{
+			{Range: rng(0, 0, 0, 1), Message: "pyright2"},
+			{Range: rng(1, 0, 1, 1), Message: "ruff2"},
+		},
+	}
+	select {
+	case got := <-published:
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("published params mismatch (-want +got):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("diagnostics are not published")
+	}
+
+	select {
+	case got := <-published:
+		t.Errorf("unexpected publication: %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDiagnosticRegistry_PublishImmediately(t *testing.T) {
+	const uri = "file:///a.py"
+	r := NewDiagnosticRegistry(DiagnosticsConfig{}, []string{"ruff"})
+	var published []protocol.PublishDiagnosticsParams
+	r.publish = func(_ context.Context, params protocol.PublishDiagnosticsParams) error {
+		published = append(published, params)
+		return nil
+	}
+
+	r.UpdateDiagnostics(uri, "ruff", 1, []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "ruff1"}})
+	r.Publish(context.Background(), uri)
+
+	want := []protocol.PublishDiagnosticsParams{{
+		Uri:         uri,
+		Version:     1,
+		Diagnostics: []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "ruff1"}},
+	}}
+	if diff := cmp.Diff(want, published); diff != "" {
+		t.Errorf("published params mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiagnosticRegistry_PublishTrailing(t *testing.T) {
+	const uri = "file:///a.py"
+	const debounce = 100 * time.Millisecond
+	r := NewDiagnosticRegistry(DiagnosticsConfig{Debounce: debounce}, []string{"ruff"})
+	published := make(chan protocol.PublishDiagnosticsParams, 10)
+	r.publish = func(_ context.Context, params protocol.PublishDiagnosticsParams) error {
+		published <- params
+		return nil
+	}
+
+	// updates keep coming longer than the window, but never pause for it
+	ctx := context.Background()
+	for version := int32(1); version <= 4; version++ {
+		r.UpdateDiagnostics(uri, "ruff", version, []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "ruff"}})
+		r.Publish(ctx, uri)
+		time.Sleep(debounce * 2 / 5)
+	}
+
+	select {
+	case got := <-published:
+		if got.Version != 4 {
+			t.Errorf("published version = %d, want 4", got.Version)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("diagnostics are not published")
+	}
+	select {
+	case got := <-published:
+		t.Errorf("unexpected publication: %v", got)
+	case <-time.After(2 * debounce):
+	}
+}
+
+func TestDiagnosticRegistry_Refresh(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           DiagnosticMode
+		refreshSupport bool
+		want           int
+	}{
+		{name: "pull mode", mode: DiagnosticModePull, refreshSupport: true, want: 1},
+		{name: "refresh not supported", mode: DiagnosticModePull, refreshSupport: false, want: 0},
+		{name: "push mode", mode: DiagnosticModePush, refreshSupport: true, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewDiagnosticRegistry(DiagnosticsConfig{Mode: tt.mode}, []string{"ruff"})
+			got := 0
+			r.refresh = func(context.Context) error {
+				got++
+				return nil
+			}
+			r.SetRefreshSupport(tt.refreshSupport)
+
+			if err := r.Refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("refresh count = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSimilarMessage(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Undefined name `foo`", "undefined name foo", true},
+		{"\"foo\" is not defined", "Name \"foo\" is not defined", true},
+		{"unused import", "undefined name", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := isSimilarMessage(tt.a, tt.b); got != tt.want {
+			t.Errorf("isSimilarMessage(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
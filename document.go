@@ -0,0 +1,171 @@
+package lsmux
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+type Document struct {
+	URI        protocol.DocumentUri
+	LanguageID protocol.LanguageKind
+	Version    int32
+	Text       string
+	// VersionOffset is added to the version of the client when the document is sent to servers,
+	// since servers also receive changes that the client did not make (e.g. by the formatting pipeline).
+	VersionOffset int32
+}
+
+// ServerVersion returns the latest version of the document sent to servers.
+func (d Document) ServerVersion() int32 {
+	return d.Version + d.VersionOffset
+}
+
+// DocumentSummary is a document without its text, used for debugging.
+type DocumentSummary struct {
+	URI        protocol.DocumentUri  `json:"uri"`
+	LanguageID protocol.LanguageKind `json:"languageId"`
+	Version    int32                 `json:"version"`
+	Size       int                   `json:"size"`
+}
+
+func (d Document) Summary() DocumentSummary {
+	return DocumentSummary{
+		URI:        d.URI,
+		LanguageID: d.LanguageID,
+		Version:    d.Version,
+		Size:       len(d.Text),
+	}
+}
+
+// DocumentStore keeps documents opened by the client.
+type DocumentStore struct {
+	mu               sync.Mutex
+	docs             map[protocol.DocumentUri]*Document
+	positionEncoding protocol.PositionEncodingKind
+}
+
+func NewDocumentStore() *DocumentStore {
+	return &DocumentStore{
+		docs:             make(map[protocol.DocumentUri]*Document),
+		positionEncoding: protocol.PositionEncodingKindUTF16,
+	}
+}
+
+// SetPositionEncoding sets the position encoding negotiated with the client.
+func (s *DocumentStore) SetPositionEncoding(enc protocol.PositionEncodingKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.positionEncoding = enc
+}
+
+func (s *DocumentStore) PositionEncoding() protocol.PositionEncodingKind {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.positionEncoding
+}
+
+func (s *DocumentStore) Open(item protocol.TextDocumentItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.docs[item.Uri] = &Document{
+		URI:        item.Uri,
+		LanguageID: item.LanguageId,
+		Version:    item.Version,
+		Text:       item.Text,
+	}
+}
+
+// Change applies content changes to the document.
+func (s *DocumentStore) Change(params protocol.DidChangeTextDocumentParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.docs[params.TextDocument.Uri]
+	if !ok {
+		return fmt.Errorf("document not opened: %s", params.TextDocument.Uri)
+	}
+
+	for _, change := range params.ContentChanges {
+		switch v := change.Value.(type) {
+		case protocol.TextDocumentContentChangePartial:
+			text, err := applyTextEdits(doc.Text, []protocol.TextEdit{{Range: v.Range, NewText: v.Text}}, s.positionEncoding)
+			if err != nil {
+				return err
+			}
+			doc.Text = text
+		case protocol.TextDocumentContentChangeWholeDocument:
+			doc.Text = v.Text
+		default:
+			return fmt.Errorf("invalid content change type: %T", v)
+		}
+	}
+	doc.Version = params.TextDocument.Version
+	return nil
+}
+
+// NextSyntheticVersion returns a new version for a change sent to servers but not made by the client.
+// The version is greater than any version of the document sent to servers before.
+func (s *DocumentStore) NextSyntheticVersion(uri protocol.DocumentUri) (int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.docs[uri]
+	if !ok {
+		return 0, fmt.Errorf("document not opened: %s", uri)
+	}
+	doc.VersionOffset++
+	return doc.ServerVersion(), nil
+}
+
+// ClientVersion converts a version of the document sent to servers to the version of the client.
+// Versions sent before the latest synthetic change are converted to older versions than the current one,
+// so that results for intermediate contents are treated as stale.
+func (s *DocumentStore) ClientVersion(uri protocol.DocumentUri, serverVersion int32) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.docs[uri]
+	if !ok || serverVersion == 0 {
+		return serverVersion
+	}
+	return serverVersion - doc.VersionOffset
+}
+
+func (s *DocumentStore) Close(uri protocol.DocumentUri) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.docs, uri)
+}
+
+// Get returns a copy of the document.
+func (s *DocumentStore) Get(uri protocol.DocumentUri) (Document, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.docs[uri]
+	if !ok {
+		return Document{}, false
+	}
+	return *doc, true
+}
+
+// List returns copies of all documents ordered by URI.
+func (s *DocumentStore) List() []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs := make([]Document, 0, len(s.docs))
+	for _, doc := range s.docs {
+		docs = append(docs, *doc)
+	}
+	slices.SortFunc(docs, func(a, b Document) int { return cmp.Compare(a.URI, b.URI) })
+	return docs
+}
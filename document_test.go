@@ -0,0 +1,100 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestDocumentStore(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(protocol.TextDocumentItem{Uri: "file:///a.py", LanguageId: "python", Version: 1, Text: "import os\nprint(1)\n"})
+
+	var params protocol.DidChangeTextDocumentParams
+	if err := json.Unmarshal([]byte(`{
+		"textDocument": {"uri": "file:///a.py", "version": 2},
+		"contentChanges": [
+			{"range": {"start": {"line": 1, "character": 6}, "end": {"line": 1, "character": 7}}, "text": "2"},
+			{"range": {"start": {"line": 0, "character": 0}, "end": {"line": 0, "character": 0}}, "text": "import sys\n"}
+		]
+	}`), &params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Change(params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := Document{URI: "file:///a.py", LanguageID: "python", Version: 2, Text: "import sys\nimport os\nprint(2)\n"}
+	got, found := s.Get("file:///a.py")
+	if !found {
+		t.Fatal("document not found")
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("document mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := json.Unmarshal([]byte(`{"textDocument": {"uri": "file:///a.py", "version": 3}, "contentChanges": [{"text": "pass\n"}]}`), &params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Change(params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := s.Get("file:///a.py"); got.Text != "pass\n" || got.Version != 3 {
+		t.Errorf("document = %v, want text %q and version 3", got, "pass\n")
+	}
+
+	s.Close("file:///a.py")
+	if _, found := s.Get("file:///a.py"); found {
+		t.Error("closed document found")
+	}
+}
+
+func TestDocumentStore_List(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(protocol.TextDocumentItem{Uri: "file:///b.py", LanguageId: "python", Version: 3, Text: "pass\n"})
+	s.Open(protocol.TextDocumentItem{Uri: "file:///a.ts", LanguageId: "typescript", Version: 1, Text: "let x = 1;\n"})
+	s.Open(protocol.TextDocumentItem{Uri: "file:///c.go", LanguageId: "go", Version: 1, Text: "package c\n"})
+	s.Close("file:///c.go")
+
+	want := []DocumentSummary{
+		{URI: "file:///a.ts", LanguageID: "typescript", Version: 1, Size: 11},
+		{URI: "file:///b.py", LanguageID: "python", Version: 3, Size: 5},
+	}
+	var got []DocumentSummary
+	for _, doc := range s.List() {
+		got = append(got, doc.Summary())
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("documents mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDocumentStore_SyntheticVersion(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(protocol.TextDocumentItem{Uri: "file:///a.py", LanguageId: "python", Version: 1, Text: "pass\n"})
+
+	for _, want := range []int32{2, 3} {
+		if got, err := s.NextSyntheticVersion("file:///a.py"); err != nil || got != want {
+			t.Errorf("NextSyntheticVersion() = %v, %v, want %v", got, err, want)
+		}
+	}
+	if _, err := s.NextSyntheticVersion("file:///b.py"); err == nil {
+		t.Error("NextSyntheticVersion() of a closed document should fail")
+	}
+
+	tests := []struct {
+		serverVersion int32
+		want          int32
+	}{
+		{serverVersion: 3, want: 1},
+		{serverVersion: 2, want: 0},
+		{serverVersion: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := s.ClientVersion("file:///a.py", tt.serverVersion); got != tt.want {
+			t.Errorf("ClientVersion(%d) = %d, want %d", tt.serverVersion, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package lsmux
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+var formattingCapabilities = []string{
+	"documentFormattingProvider",
+	"documentRangeFormattingProvider",
+	"documentOnTypeFormattingProvider",
+}
+
+type FormatterConfig struct {
+	// Rules select the server that formats a document. The first matching rule is used.
+	Rules []FormatterRule `yaml:"rules"`
+	// HideOthers hides formatting capabilities of servers that are not selected by any rule,
+	// and prevents servers not selected by the matching rule from formatting the document even if the selected servers do not support the method.
+	HideOthers bool `yaml:"hideOthers"`
+}
+
+// FormatterRule selects the server for documents matching any of Languages or Patterns.
+type FormatterRule struct {
+	Languages []protocol.LanguageKind `yaml:"languages"`
+	Patterns  []Glob                  `yaml:"patterns"`
+	Server    string                  `yaml:"server"`
+	// Pipeline formats the document with servers in order instead of Server.
+	// Each server formats the result of the preceding server.
+	Pipeline []string `yaml:"pipeline"`
+}
+
+// Servers returns the servers used by the rule.
+func (r FormatterRule) Servers() []string {
+	if len(r.Pipeline) != 0 {
+		return r.Pipeline
+	}
+	return []string{r.Server}
+}
+
+func (r FormatterRule) Match(doc Document) bool {
+	if slices.Contains(r.Languages, doc.LanguageID) {
+		return true
+	}
+	return slices.ContainsFunc(r.Patterns, func(g Glob) bool { return g.MatchURI(doc.URI) })
+}
+
+// Lookup returns the first rule matching the document.
+func (c FormatterConfig) Lookup(doc Document) (FormatterRule, bool) {
+	i := slices.IndexFunc(c.Rules, func(r FormatterRule) bool { return r.Match(doc) })
+	if i == -1 {
+		return FormatterRule{}, false
+	}
+	return c.Rules[i], true
+}
+
+// IsFormatter reports whether the server is selected by any rule.
+func (c FormatterConfig) IsFormatter(serverName string) bool {
+	return slices.ContainsFunc(c.Rules, func(r FormatterRule) bool { return slices.Contains(r.Servers(), serverName) })
+}
+
+func (c FormatterConfig) validate(serverNames []string) error {
+	for i, r := range c.Rules {
+		if len(r.Languages) == 0 && len(r.Patterns) == 0 {
+			return fmt.Errorf("formatter.rules[%d]: languages or patterns is required", i)
+		}
+		if (r.Server == "") == (len(r.Pipeline) == 0) {
+			return fmt.Errorf("formatter.rules[%d]: either server or pipeline is required", i)
+		}
+		for _, name := range r.Servers() {
+			if !slices.Contains(serverNames, name) {
+				return fmt.Errorf("formatter.rules[%d]: server not found in config: %s", i, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package lsmux
+
+import (
+	"testing"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestFormatterConfig_Lookup(t *testing.T) {
+	cfg := FormatterConfig{
+		Rules: []FormatterRule{
+			{Languages: []protocol.LanguageKind{"vue"}, Server: "eslint"},
+			{Patterns: []Glob{MustCompileGlob("*.ts")}, Server: "tsls"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		doc   Document
+		want  string
+		found bool
+	}{
+		{name: "language", doc: Document{URI: "file:///src/a.vue", LanguageID: "vue"}, want: "eslint", found: true},
+		{name: "pattern", doc: Document{URI: "file:///src/a.ts", LanguageID: "typescript"}, want: "tsls", found: true},
+		{name: "unknown language", doc: Document{URI: "file:///src/a.ts"}, want: "tsls", found: true},
+		{name: "not found", doc: Document{URI: "file:///src/a.js", LanguageID: "javascript"}, found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, found := cfg.Lookup(tt.doc)
+			if found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if rule.Server != tt.want {
+				t.Errorf("rule.Server = %v, want %v", rule.Server, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package lsmux
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// Glob is a glob pattern of the LSP syntax.
+//
+// It supports `*`, `?`, `**`, `{a,b}`, `[a-z]` and `[!a-z]`.
+// A pattern without `/` matches the base name of the path,
+// and a relative pattern with `/` matches trailing components of the path (e.g. `src/*.ts` matches `/home/user/src/a.ts`).
+type Glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func CompileGlob(pattern string) (Glob, error) {
+	expr, err := globToRegexp(pattern)
+	if err != nil {
+		return Glob{}, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Glob{}, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	return Glob{pattern: pattern, re: re}, nil
+}
+
+func MustCompileGlob(pattern string) Glob {
+	g, err := CompileGlob(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func (g Glob) String() string {
+	return g.pattern
+}
+
+func (g Glob) Equal(other Glob) bool {
+	return g.pattern == other.pattern
+}
+
+// Match reports whether the path matches the pattern.
+func (g Glob) Match(p string) bool {
+	if g.re == nil {
+		return false
+	}
+	if !strings.Contains(g.pattern, "/") {
+		p = path.Base(p)
+	}
+	return g.re.MatchString(p)
+}
+
+// MatchURI reports whether the path of the document uri matches the pattern.
+func (g Glob) MatchURI(uri protocol.DocumentUri) bool {
+	return g.Match(uriToPath(uri))
+}
+
+func (g *Glob) UnmarshalYAML(b []byte) error {
+	var pattern string
+	if err := yaml.Unmarshal(b, &pattern); err != nil {
+		return err
+	}
+	v, err := CompileGlob(pattern)
+	if err != nil {
+		return err
+	}
+	*g = v
+	return nil
+}
+
+func globToRegexp(pattern string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	if strings.Contains(pattern, "/") && !strings.HasPrefix(pattern, "/") {
+		sb.WriteString("(?:.*/)?")
+	}
+
+	braceDepth := 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") {
+				switch {
+				case strings.HasPrefix(pattern[i:], "**/"):
+					sb.WriteString("(?:.*/)?")
+					i += 2
+				default:
+					sb.WriteString(".*")
+					i++
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				return "", fmt.Errorf("unclosed '['")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '{':
+			braceDepth++
+			sb.WriteString("(?:")
+		case '}':
+			if braceDepth == 0 {
+				return "", fmt.Errorf("unexpected '}'")
+			}
+			braceDepth--
+			sb.WriteString(")")
+		case ',':
+			if braceDepth > 0 {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if braceDepth != 0 {
+		return "", fmt.Errorf("unclosed '{'")
+	}
+
+	sb.WriteString("$")
+	return sb.String(), nil
+}
+
+// uriToPath returns the path of the file uri, or the uri itself if it is not a file uri.
+func uriToPath(uri protocol.DocumentUri) string {
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != "file" {
+		return string(uri)
+	}
+	return u.Path
+}
@@ -0,0 +1,56 @@
+package lsmux
+
+import (
+	"testing"
+)
+
+func TestGlob_Match(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "*.ts", path: "/home/user/src/a.ts", want: true},
+		{pattern: "*.ts", path: "/home/user/src/a.tsx", want: false},
+		{pattern: "**/*.ts", path: "/home/user/src/a.ts", want: true},
+		{pattern: "**/*.ts", path: "a.ts", want: true},
+		{pattern: "/home/*/src/*.ts", path: "/home/user/src/a.ts", want: true},
+		{pattern: "/home/*/*.ts", path: "/home/user/src/a.ts", want: false},
+		{pattern: "/home/**", path: "/home/user/src/a.ts", want: true},
+		{pattern: "**/src/**/*.{ts,vue}", path: "/home/user/src/components/a.vue", want: true},
+		{pattern: "**/src/**/*.{ts,vue}", path: "/home/user/src/a.js", want: false},
+		{pattern: "src/**/*.vue", path: "/home/user/proj/src/a.vue", want: true},
+		{pattern: "src/*.vue", path: "/home/user/proj/src/components/a.vue", want: false},
+		{pattern: "src/*.vue", path: "/home/user/mysrc/a.vue", want: false},
+		{pattern: "a?.py", path: "/src/ab.py", want: true},
+		{pattern: "[!a]b.py", path: "/src/ab.py", want: false},
+		{pattern: "[a-c]b.py", path: "/src/ab.py", want: true},
+		{pattern: "a+b.py", path: "/src/a+b.py", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			g, err := CompileGlob(tt.pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := g.Match(tt.path); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlob_MatchURI(t *testing.T) {
+	g := MustCompileGlob("**/src/*.py")
+	if !g.MatchURI("file:///home/user/my%20project/src/a.py") {
+		t.Errorf("MatchURI() = false, want true")
+	}
+}
+
+func TestCompileGlob_Errors(t *testing.T) {
+	for _, pattern := range []string{"[a", "{a,b", "a}"} {
+		if _, err := CompileGlob(pattern); err == nil {
+			t.Errorf("CompileGlob(%q) expected error, got nil", pattern)
+		}
+	}
+}
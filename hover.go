@@ -0,0 +1,204 @@
+package lsmux
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/buzztaiki/lsmux/capability"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// HoverMerger concatenates hover results from multiple servers into a single MarkupContent.
+type HoverMerger struct {
+	cfg      HoverConfig
+	kind     protocol.MarkupKind
+	contents []string
+	rng      *protocol.Range
+}
+
+func NewHoverMerger(cfg HoverConfig, kind protocol.MarkupKind) *HoverMerger {
+	return &HoverMerger{cfg: cfg, kind: kind}
+}
+
+// Add adds a hover result of the server.
+func (m *HoverMerger) Add(serverName string, res json.RawMessage) error {
+	if isEmptyResult(res) {
+		return nil
+	}
+
+	var hover struct {
+		Contents json.RawMessage `json:"contents"`
+		Range    *protocol.Range `json:"range"`
+	}
+	if err := json.Unmarshal(res, &hover); err != nil {
+		return err
+	}
+
+	content, err := m.convertContents(hover.Contents)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+
+	if m.cfg.ServerHeader {
+		if m.kind == protocol.MarkupKindMarkdown {
+			content = "**" + escapeMarkdown(serverName) + "**\n\n" + content
+		} else {
+			content = serverName + "\n\n" + content
+		}
+	}
+	m.contents = append(m.contents, content)
+
+	if hover.Range != nil {
+		m.rng = enclosingRange(m.rng, hover.Range)
+	}
+	return nil
+}
+
+// Result returns the merged hover, or nil if there is no hover content.
+func (m *HoverMerger) Result() *protocol.Hover {
+	if len(m.contents) == 0 {
+		return nil
+	}
+
+	return &protocol.Hover{
+		Contents: protocol.Or3[protocol.MarkupContent, protocol.MarkedString, []protocol.MarkedString]{
+			Value: protocol.MarkupContent{
+				Kind:  m.kind,
+				Value: strings.Join(m.contents, m.separator()),
+			},
+		},
+		Range: m.rng,
+	}
+}
+
+// separator returns the configured separator, or a separator suitable for the merger's kind.
+func (m *HoverMerger) separator() string {
+	switch {
+	case m.cfg.Separator != "":
+		return m.cfg.Separator
+	case m.kind == protocol.MarkupKindMarkdown:
+		return "\n\n---\n\n"
+	default:
+		return "\n\n"
+	}
+}
+
+// convertContents converts hover contents (MarkupContent, MarkedString or []MarkedString) to a string of the merger's kind.
+func (m *HoverMerger) convertContents(contents json.RawMessage) (string, error) {
+	contents = bytes.TrimSpace(contents)
+	if len(contents) == 0 {
+		return "", nil
+	}
+
+	switch contents[0] {
+	case '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(contents, &items); err != nil {
+			return "", err
+		}
+		var res []string
+		for _, item := range items {
+			s, err := m.convertContents(item)
+			if err != nil {
+				return "", err
+			}
+			res = append(res, s)
+		}
+		return strings.Join(res, "\n\n"), nil
+	case '"':
+		// MarkedString is markdown
+		var s string
+		if err := json.Unmarshal(contents, &s); err != nil {
+			return "", err
+		}
+		if m.kind == protocol.MarkupKindPlainText {
+			return markdownToPlainText(s), nil
+		}
+		return s, nil
+	case '{':
+		var v struct {
+			Kind     protocol.MarkupKind `json:"kind"`
+			Language string              `json:"language"`
+			Value    string              `json:"value"`
+		}
+		if err := json.Unmarshal(contents, &v); err != nil {
+			return "", err
+		}
+
+		switch {
+		case v.Kind == protocol.MarkupKindPlainText && m.kind == protocol.MarkupKindMarkdown:
+			return escapeMarkdown(v.Value), nil
+		case v.Kind == protocol.MarkupKindMarkdown && m.kind == protocol.MarkupKindPlainText:
+			return markdownToPlainText(v.Value), nil
+		case v.Kind != "":
+			return v.Value, nil
+		case m.kind == protocol.MarkupKindMarkdown:
+			return "```" + v.Language + "\n" + v.Value + "\n```", nil
+		default:
+			return v.Value, nil
+		}
+	default:
+		return "", fmt.Errorf("invalid hover contents: %s", contents)
+	}
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+var (
+	markdownHeaderRegexp = regexp.MustCompile(`^ {0,3}#{1,6}(\s+|$)`)
+	markdownRuleRegexp   = regexp.MustCompile(`^ {0,3}(-( *-){2,}|\*( *\*){2,}|_( *_){2,}) *$`)
+	// escaped punctuation, strong emphasis or code span
+	markdownInlineRegexp = regexp.MustCompile(`\\[!-/:-@\[-` + "`" + `{-~]|\*\*|` + "`")
+)
+
+// markdownToPlainText strips markdown syntax such as code fences, headers and emphasis for clients that only support plaintext.
+// Contents of code blocks are kept as is.
+func markdownToPlainText(s string) string {
+	var res []string
+	inCode := false
+	for line := range strings.SplitSeq(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if !inCode {
+			if markdownRuleRegexp.MatchString(line) {
+				line = ""
+			}
+			line = markdownHeaderRegexp.ReplaceAllString(line, "")
+			line = markdownInlineRegexp.ReplaceAllStringFunc(line, func(s string) string {
+				if unescaped, ok := strings.CutPrefix(s, `\`); ok {
+					return unescaped
+				}
+				return ""
+			})
+		}
+		res = append(res, line)
+	}
+	return strings.Join(res, "\n")
+}
+
+// hoverContentFormat returns the most preferred hover content format supported by the client.
+func hoverContentFormat(clientCaps map[string]any) protocol.MarkupKind {
+	v, _ := capability.Lookup(clientCaps, "textDocument.hover.contentFormat")
+	formats, _ := v.([]any)
+	for _, f := range formats {
+		switch kind := protocol.MarkupKind(fmt.Sprint(f)); kind {
+		case protocol.MarkupKindMarkdown, protocol.MarkupKindPlainText:
+			return kind
+		}
+	}
+	return protocol.MarkupKindPlainText
+}
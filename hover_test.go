@@ -0,0 +1,98 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestHoverMerger(t *testing.T) {
+	type result struct {
+		server string
+		res    string
+	}
+
+	tests := []struct {
+		name      string
+		cfg       HoverConfig
+		kind      protocol.MarkupKind
+		results   []result
+		wantValue string
+		wantRange *protocol.Range
+	}{
+		{
+			name: "markdown",
+			cfg:  HoverConfig{Separator: "\n---\n"},
+			kind: protocol.MarkupKindMarkdown,
+			results: []result{
+				{"pyright", `{"contents": {"kind": "markdown", "value": "**foo**"}, "range": {"start": {"line": 1, "character": 4}, "end": {"line": 1, "character": 7}}}`},
+				{"ruff", `null`},
+				{"other", `{"contents": [{"language": "python", "value": "def foo()"}, "doc"], "range": {"start": {"line": 1, "character": 0}, "end": {"line": 1, "character": 5}}}`},
+				{"plain", `{"contents": {"kind": "plaintext", "value": "a_b"}}`},
+			},
+			wantValue: "**foo**\n---\n```python\ndef foo()\n```\n\ndoc\n---\na\\_b",
+			wantRange: &protocol.Range{Start: protocol.Position{Line: 1, Character: 0}, End: protocol.Position{Line: 1, Character: 7}},
+		},
+		{
+			name: "plaintext with header",
+			cfg:  HoverConfig{Separator: "\n\n", ServerHeader: true},
+			kind: protocol.MarkupKindPlainText,
+			results: []result{
+				{"pyright", `{"contents": {"kind": "markdown", "value": "foo"}}`},
+				{"other", `{"contents": {"language": "python", "value": "def foo()"}}`},
+			},
+			wantValue: "pyright\n\nfoo\n\nother\n\ndef foo()",
+		},
+		{
+			name: "markdown to plaintext",
+			kind: protocol.MarkupKindPlainText,
+			results: []result{
+				{"pyright", `{"contents": {"kind": "markdown", "value": "# foo\n\n` + "```python\\ndef foo(**kwargs)\\n```" + `\n\n---\n**Returns** ` + "`int`" + ` or a\\_b"}}`},
+				{"other", `{"contents": ["## bar", {"language": "python", "value": "bar: int"}]}`},
+			},
+			wantValue: "foo\n\ndef foo(**kwargs)\n\n\nReturns int or a_b\n\nbar\n\nbar: int",
+		},
+		{
+			name: "default markdown separator",
+			kind: protocol.MarkupKindMarkdown,
+			results: []result{
+				{"pyright", `{"contents": "foo"}`},
+				{"other", `{"contents": "bar"}`},
+			},
+			wantValue: "foo\n\n---\n\nbar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewHoverMerger(tt.cfg, tt.kind)
+			for _, r := range tt.results {
+				if err := m.Add(r.server, json.RawMessage(r.res)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			got := m.Result()
+			want := &protocol.Hover{
+				Contents: protocol.Or3[protocol.MarkupContent, protocol.MarkedString, []protocol.MarkedString]{
+					Value: protocol.MarkupContent{Kind: tt.kind, Value: tt.wantValue},
+				},
+				Range: tt.wantRange,
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		m := NewHoverMerger(HoverConfig{}, protocol.MarkupKindMarkdown)
+		if err := m.Add("server", json.RawMessage(`null`)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := m.Result(); got != nil {
+			t.Errorf("Result() = %v, want nil", got)
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package lsmux
+
+import (
+	"strings"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// dedupInlayHints removes hints with the same position and label, keeping the first one.
+func dedupInlayHints(hints []protocol.InlayHint) []protocol.InlayHint {
+	type key struct {
+		pos   protocol.Position
+		label string
+	}
+
+	seen := map[key]struct{}{}
+	res := []protocol.InlayHint{}
+	for _, hint := range hints {
+		k := key{hint.Position, inlayHintLabelText(hint)}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		res = append(res, hint)
+	}
+	return res
+}
+
+func inlayHintLabelText(hint protocol.InlayHint) string {
+	switch v := hint.Label.Value.(type) {
+	case string:
+		return v
+	case []protocol.InlayHintLabelPart:
+		var sb strings.Builder
+		for _, part := range v {
+			sb.WriteString(part.Value)
+		}
+		return sb.String()
+	default:
+		return ""
+	}
+}
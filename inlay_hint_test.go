@@ -0,0 +1,27 @@
+package lsmux
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestDedupInlayHints(t *testing.T) {
+	type label = protocol.Or2[string, []protocol.InlayHintLabelPart]
+	pos := func(line, char uint32) protocol.Position {
+		return protocol.Position{Line: line, Character: char}
+	}
+
+	hints := []protocol.InlayHint{
+		{Position: pos(1, 5), Label: label{Value: ": number"}, Data: "tsls"},
+		{Position: pos(1, 5), Label: label{Value: []protocol.InlayHintLabelPart{{Value: ": "}, {Value: "number"}}}, Data: "vuels"},
+		{Position: pos(1, 5), Label: label{Value: ": string"}},
+		{Position: pos(2, 5), Label: label{Value: ": number"}},
+	}
+	want := []protocol.InlayHint{hints[0], hints[2], hints[3]}
+
+	if diff := cmp.Diff(want, dedupInlayHints(hints)); diff != "" {
+		t.Errorf("dedupInlayHints() mismatch (-want +got):\n%s", diff)
+	}
+}
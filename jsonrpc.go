@@ -1,7 +1,9 @@
 package lsmux
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"os"
 	"os/exec"
@@ -30,40 +32,73 @@ func NewIOPipeListener(ctx context.Context, r io.Reader, w io.Writer) (jsonrpc2.
 		return nil, err
 	}
 
-	go bindIOToListener(ctx, pipe, r, w)
+	go bindIOToListener(ctx, pipe, r, w, make(chan struct{}))
 	return pipe, nil
 }
 
-func NewCmdPipeListener(ctx context.Context, cmd *exec.Cmd) (jsonrpc2.Listener, error) {
+// NewCmdPipeListener starts the command and returns a listener bound to its stdin and stdout.
+// The returned channel is closed when reading stdout is finished, and cmd.Wait must not be called before it.
+func NewCmdPipeListener(ctx context.Context, cmd *exec.Cmd) (jsonrpc2.Listener, <-chan struct{}, error) {
 	pipe, err := jsonrpc2.NetPipe(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Start(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	go bindIOToListener(ctx, pipe, stdout, stdin)
-	return pipe, nil
+	done := make(chan struct{})
+	go bindIOToListener(ctx, pipe, stdout, stdin, done)
+	return pipe, done, nil
 }
 
-func bindIOToListener(ctx context.Context, l jsonrpc2.Listener, r io.Reader, w io.Writer) error {
+// bindIOToListener connects r and w to the listener, and closes done when reading r is finished.
+func bindIOToListener(ctx context.Context, l jsonrpc2.Listener, r io.Reader, w io.Writer, done chan<- struct{}) error {
 	rwc, err := l.Accept(ctx)
 	if err != nil {
+		close(done)
 		return err
 	}
-	go io.Copy(rwc, r)
+	go func() {
+		// close the connection when the reader reaches EOF (e.g. the process exited)
+		defer rwc.Close()
+		defer close(done)
+		io.Copy(rwc, r)
+	}()
 	go io.Copy(w, rwc)
 	return nil
 }
+
+// isEmptyResult reports whether res is null or an empty array or object.
+func isEmptyResult(res json.RawMessage) bool {
+	switch string(bytes.TrimSpace(res)) {
+	case "", "null", "[]", "{}":
+		return true
+	default:
+		return false
+	}
+}
+
+// firstNonEmptyResult returns the first non-empty result, or the first result if all results are empty.
+func firstNonEmptyResult(results []json.RawMessage) json.RawMessage {
+	for _, res := range results {
+		if !isEmptyResult(res) {
+			return res
+		}
+	}
+	if len(results) == 0 || len(results[0]) == 0 {
+		return json.RawMessage("null")
+	}
+	return results[0]
+}
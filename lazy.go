@@ -0,0 +1,114 @@
+package lsmux
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/buzztaiki/lsmux/capability"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// AddLazyServer adds the supervisor of a lazy server, which is started when the client opens a matching document.
+func (h *ClientHandler) AddLazyServer(supervisor *ServerSupervisor) {
+	h.lazyServers[supervisor.server.Name] = supervisor
+}
+
+// startLazyServers starts lazy servers matching the document.
+func (h *ClientHandler) startLazyServers(ctx context.Context, doc Document) {
+	for _, server := range h.serverRegistry.Servers() {
+		supervisor, found := h.lazyServers[server.Name]
+		if !found || server.Started() || !server.MatchDocument(doc) {
+			continue
+		}
+
+		// the server process must outlive the request, and the supervisor restores the session to the server
+		if err := supervisor.Start(context.WithoutCancel(ctx)); err != nil {
+			slog.WarnContext(ctx, "failed to start lazy server", "server", server.Name, "error", err)
+		}
+	}
+}
+
+// registerCapabilities registers capabilities of the server which are not advertised to the client.
+// The registrations are recorded as registrations of the server, so that they are unregistered when the server exits.
+func (h *ClientHandler) registerCapabilities(ctx context.Context, server *ServerConnection, kvCaps map[string]any) error {
+	h.mu.Lock()
+	registrations := capabilityRegistrations(server, kvCaps, h.serverCapabilities, h.clientCapabilities)
+	h.mu.Unlock()
+
+	if len(registrations) == 0 {
+		return nil
+	}
+	ids := make([]string, len(registrations))
+	for i, reg := range registrations {
+		ids[i] = reg.Id
+		server.Register(reg.Id, NewRegistration(reg.Method, reg.RegisterOptions))
+		registrations[i].Id = registrationID(server.Name, reg.Id)
+	}
+	slog.DebugContext(ctx, "register capabilities", "server", server.Name, "registrations", registrations)
+	params := protocol.RegistrationParams{Registrations: registrations}
+	if err := h.clientConn.Call(ctx, string(protocol.ClientRegisterCapabilityMethod), params).Await(ctx, nil); err != nil {
+		for _, id := range ids {
+			server.Unregister(id)
+		}
+		return err
+	}
+	return nil
+}
+
+// capabilityRegistrations returns registrations of methods supported by kvCaps but not by advertisedCaps.
+// Only methods whose dynamic registration is supported by the client are registered.
+// The IDs of the registrations are the methods, which are unique in the server.
+func capabilityRegistrations(server *ServerConnection, kvCaps, advertisedCaps, clientCaps map[string]any) []protocol.Registration {
+	var res []protocol.Registration
+	for _, method := range slices.Sorted(maps.Keys(capability.MethodToCapability)) {
+		// registration options of nested capabilities are the same as their parents
+		capKey := capability.MethodToCapability[method]
+		if strings.Contains(capKey, ".") {
+			continue
+		}
+		if !isCapabilityEnabled(kvCaps[capKey]) || isCapabilityEnabled(advertisedCaps[capKey]) {
+			continue
+		}
+		if !capability.IsEnabled(clientCaps, strings.ReplaceAll(method, "/", ".")+".dynamicRegistration") {
+			continue
+		}
+
+		options := map[string]any{}
+		if v, ok := kvCaps[capKey].(map[string]any); ok {
+			maps.Copy(options, v)
+		}
+		if strings.HasPrefix(method, "textDocument/") {
+			options["documentSelector"] = documentSelector(server)
+		}
+		res = append(res, protocol.Registration{
+			Id:              method,
+			Method:          method,
+			RegisterOptions: options,
+		})
+	}
+	return res
+}
+
+func isCapabilityEnabled(v any) bool {
+	return v != nil && v != false
+}
+
+// documentSelector returns the LSP document selector of the server's languages and file patterns.
+func documentSelector(server *ServerConnection) []map[string]any {
+	selector := []map[string]any{}
+	for _, lang := range server.Languages {
+		selector = append(selector, map[string]any{"language": lang})
+	}
+	for _, g := range server.FilePatterns {
+		pattern := g.String()
+		// a pattern without "/" matches the file name
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		selector = append(selector, map[string]any{"pattern": pattern})
+	}
+	return selector
+}
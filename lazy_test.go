@@ -0,0 +1,206 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/exp/jsonrpc2"
+)
+
+func TestCapabilityRegistrations(t *testing.T) {
+	server := &ServerConnection{
+		Name:         "vuels",
+		Languages:    []protocol.LanguageKind{"vue"},
+		FilePatterns: []Glob{MustCompileGlob("*.vue")},
+	}
+	kvCaps := map[string]any{
+		"hoverProvider":              true,
+		"completionProvider":         map[string]any{"triggerCharacters": []any{"."}},
+		"definitionProvider":         true,
+		"renameProvider":             false,
+		"workspaceSymbolProvider":    true,
+		"documentFormattingProvider": true,
+	}
+	advertisedCaps := map[string]any{
+		"definitionProvider": true,
+	}
+	clientCaps := map[string]any{
+		"textDocument": map[string]any{
+			"hover":      map[string]any{"dynamicRegistration": true},
+			"completion": map[string]any{"dynamicRegistration": true},
+			"definition": map[string]any{"dynamicRegistration": true},
+			"rename":     map[string]any{"dynamicRegistration": true},
+		},
+		"workspace": map[string]any{
+			"symbol": map[string]any{"dynamicRegistration": true},
+		},
+	}
+
+	selector := []map[string]any{{"language": protocol.LanguageKind("vue")}, {"pattern": "**/*.vue"}}
+	want := []protocol.Registration{
+		{
+			Id:              "textDocument/completion",
+			Method:          "textDocument/completion",
+			RegisterOptions: map[string]any{"triggerCharacters": []any{"."}, "documentSelector": selector},
+		},
+		{
+			Id:              "textDocument/hover",
+			Method:          "textDocument/hover",
+			RegisterOptions: map[string]any{"documentSelector": selector},
+		},
+		{
+			Id:              "workspace/symbol",
+			Method:          "workspace/symbol",
+			RegisterOptions: map[string]any{},
+		},
+	}
+
+	got := capabilityRegistrations(server, kvCaps, advertisedCaps, clientCaps)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("capabilityRegistrations() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStartLazyServers(t *testing.T) {
+	backoffBase := restartBackoffBase
+	restartBackoffBase = 10 * time.Millisecond
+	t.Cleanup(func() { restartBackoffBase = backoffBase })
+	t.Setenv(helperServerEnv, "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// requests to the client in the order received
+	events := make(chan string, 10)
+	clientConn := newTestConnection(t, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		switch protocol.MethodKind(r.Method) {
+		case protocol.ClientRegisterCapabilityMethod:
+			var params protocol.RegistrationParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				return nil, err
+			}
+			for _, reg := range params.Registrations {
+				events <- r.Method + " " + reg.Id
+			}
+		case protocol.ClientUnregisterCapabilityMethod:
+			var params protocol.UnregistrationParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				return nil, err
+			}
+			for _, unreg := range params.Unregisterations {
+				events <- r.Method + " " + unreg.Id
+			}
+		case protocol.WindowShowMessageMethod:
+			var params protocol.ShowMessageParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				return nil, err
+			}
+			events <- r.Method + " " + params.Message
+		}
+		if r.IsCall() {
+			return json.RawMessage("null"), nil
+		}
+		return nil, nil
+	})
+	receive := func(n int) []string {
+		t.Helper()
+		var got []string
+		for range n {
+			select {
+			case ev := <-events:
+				got = append(got, ev)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out: %v", got)
+			}
+		}
+		return got
+	}
+
+	cfg := ServerConfig{
+		Name:        "helper",
+		Command:     os.Args[0],
+		Args:        []string{"-test.run=^TestHelperServer$"},
+		Languages:   []protocol.LanguageKind{"python"},
+		Lazy:        true,
+		MaxRestarts: 1,
+	}
+	server := NewServerConnection(cfg)
+	t.Cleanup(func() { server.Close() })
+	h := newTestClientHandler(&Config{}, server)
+	h.SetClientConn(clientConn)
+
+	restores := 0
+	restore := func(ctx context.Context, server *ServerConnection) error {
+		restores++
+		if restores == 1 {
+			return errors.New("restore failed")
+		}
+		return h.RestoreServer(ctx, server)
+	}
+	serverBinder := NewBinder(jsonrpc2.HandlerFunc(func(context.Context, *jsonrpc2.Request) (any, error) {
+		return nil, jsonrpc2.ErrNotHandled
+	}))
+	h.AddLazyServer(NewServerSupervisor(cfg, server, serverBinder, clientConn, h.diagRegistry, restore))
+
+	clientCaps := map[string]any{
+		"textDocument": map[string]any{"hover": map[string]any{"dynamicRegistration": true}},
+	}
+	testCall(t, h, string(protocol.InitializeMethod), map[string]any{"capabilities": clientCaps})
+
+	didOpen := func(uri protocol.DocumentUri) error {
+		r, err := jsonrpc2.NewNotification(string(protocol.TextDocumentDidOpenMethod), protocol.DidOpenTextDocumentParams{
+			TextDocument: protocol.TextDocumentItem{Uri: uri, LanguageId: "python", Version: 1, Text: "x"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = h.Handle(ctx, r)
+		return err
+	}
+
+	// the failed start is retried by the next matching document
+	if err := didOpen("file:///a.py"); !errors.Is(err, ErrMethodNotFound) {
+		t.Fatalf("didOpen with the failed restore: got %v, want %v", err, ErrMethodNotFound)
+	}
+	if server.Started() {
+		t.Fatal("server is started after the failed restore")
+	}
+	if err := didOpen("file:///b.py"); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Ready() {
+		t.Fatal("server is not ready after the second didOpen")
+	}
+	want := []string{"client/registerCapability helper/textDocument/hover"}
+	if diff := cmp.Diff(want, receive(len(want))); diff != "" {
+		t.Errorf("events after start mismatch (-want +got):\n%s", diff)
+	}
+
+	var uris []protocol.DocumentUri
+	if err := server.Call(ctx, "test/documents", nil, &uris); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]protocol.DocumentUri{"file:///a.py", "file:///b.py"}, uris); diff != "" {
+		t.Errorf("opened documents mismatch (-want +got):\n%s", diff)
+	}
+
+	// the registrations are withdrawn on exit and registered again on restart
+	if err := server.Notify(ctx, "test/crash", nil); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"client/unregisterCapability helper/textDocument/hover",
+		"window/showMessage lsmux: helper exited unexpectedly, restarting in 10ms (1/1)",
+		"client/registerCapability helper/textDocument/hover",
+		"window/showMessage lsmux: helper restarted",
+	}
+	if diff := cmp.Diff(want, receive(len(want))); diff != "" {
+		t.Errorf("events after restart mismatch (-want +got):\n%s", diff)
+	}
+}
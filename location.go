@@ -0,0 +1,127 @@
+package lsmux
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"strings"
+
+	"github.com/buzztaiki/lsmux/capability"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// parseLocationLinks parses a result of definition like requests (Location, []Location or []LocationLink) as []LocationLink.
+func parseLocationLinks(res json.RawMessage) ([]protocol.LocationLink, error) {
+	res = bytes.TrimSpace(res)
+	if len(res) == 0 || string(res) == "null" {
+		return nil, nil
+	}
+
+	if res[0] != '[' {
+		var loc protocol.Location
+		if err := json.Unmarshal(res, &loc); err != nil {
+			return nil, err
+		}
+		return []protocol.LocationLink{locationToLocationLink(loc)}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(res, &items); err != nil {
+		return nil, err
+	}
+
+	links := make([]protocol.LocationLink, 0, len(items))
+	for _, item := range items {
+		var probe struct {
+			TargetUri *protocol.DocumentUri `json:"targetUri"`
+		}
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return nil, err
+		}
+
+		if probe.TargetUri != nil {
+			var link protocol.LocationLink
+			if err := json.Unmarshal(item, &link); err != nil {
+				return nil, err
+			}
+			links = append(links, link)
+		} else {
+			var loc protocol.Location
+			if err := json.Unmarshal(item, &loc); err != nil {
+				return nil, err
+			}
+			links = append(links, locationToLocationLink(loc))
+		}
+	}
+	return links, nil
+}
+
+func locationToLocationLink(loc protocol.Location) protocol.LocationLink {
+	return protocol.LocationLink{
+		TargetUri:            loc.Uri,
+		TargetRange:          loc.Range,
+		TargetSelectionRange: loc.Range,
+	}
+}
+
+func locationLinkToLocation(link protocol.LocationLink) protocol.Location {
+	return protocol.Location{
+		Uri:   link.TargetUri,
+		Range: link.TargetSelectionRange,
+	}
+}
+
+// dedupLocationLinks removes links that point to the same target, keeping the first one.
+func dedupLocationLinks(links []protocol.LocationLink) []protocol.LocationLink {
+	seen := map[protocol.Location]struct{}{}
+	var res []protocol.LocationLink
+	for _, link := range links {
+		key := locationLinkToLocation(link)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		res = append(res, link)
+	}
+	return res
+}
+
+// dedupLocations removes duplicated locations, keeping the first one.
+func dedupLocations(locs []protocol.Location) []protocol.Location {
+	seen := map[protocol.Location]struct{}{}
+	var res []protocol.Location
+	for _, loc := range locs {
+		if _, ok := seen[loc]; ok {
+			continue
+		}
+		seen[loc] = struct{}{}
+		res = append(res, loc)
+	}
+	return res
+}
+
+// locationLinkSupported reports whether the client supports LocationLink as a result of the definition like method.
+func locationLinkSupported(clientCaps map[string]any, method string) bool {
+	// e.g. textDocument/definition -> textDocument.definition.linkSupport
+	return capability.IsEnabled(clientCaps, strings.ReplaceAll(method, "/", ".")+".linkSupport")
+}
+
+func comparePosition(a, b protocol.Position) int {
+	return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Character, b.Character))
+}
+
+// enclosingRange returns the smallest range that contains both a and b. a may be nil.
+func enclosingRange(a, b *protocol.Range) *protocol.Range {
+	if a == nil {
+		return b
+	}
+
+	res := *a
+	if comparePosition(b.Start, res.Start) < 0 {
+		res.Start = b.Start
+	}
+	if comparePosition(b.End, res.End) > 0 {
+		res.End = b.End
+	}
+	return &res
+}
@@ -0,0 +1,83 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestParseLocationLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		res  string
+		want []protocol.LocationLink
+	}{
+		{
+			name: "null",
+			res:  `null`,
+			want: nil,
+		},
+		{
+			name: "location",
+			res:  `{"uri": "file:///a.ts", "range": {"start": {"line": 1, "character": 2}, "end": {"line": 1, "character": 5}}}`,
+			want: []protocol.LocationLink{
+				{TargetUri: "file:///a.ts", TargetRange: rng(1, 2, 1, 5), TargetSelectionRange: rng(1, 2, 1, 5)},
+			},
+		},
+		{
+			name: "locations and links",
+			res: `[
+				{"uri": "file:///a.ts", "range": {"start": {"line": 1, "character": 2}, "end": {"line": 1, "character": 5}}},
+				{"targetUri": "file:///b.vue", "targetRange": {"start": {"line": 3, "character": 0}, "end": {"line": 5, "character": 1}}, "targetSelectionRange": {"start": {"line": 3, "character": 6}, "end": {"line": 3, "character": 9}}}
+			]`,
+			want: []protocol.LocationLink{
+				{TargetUri: "file:///a.ts", TargetRange: rng(1, 2, 1, 5), TargetSelectionRange: rng(1, 2, 1, 5)},
+				{TargetUri: "file:///b.vue", TargetRange: rng(3, 0, 5, 1), TargetSelectionRange: rng(3, 6, 3, 9)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLocationLinks(json.RawMessage(tt.res))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseLocationLinks() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDedupLocationLinks(t *testing.T) {
+	links := []protocol.LocationLink{
+		{TargetUri: "file:///a.ts", TargetRange: rng(1, 0, 3, 0), TargetSelectionRange: rng(1, 6, 1, 9)},
+		{TargetUri: "file:///a.ts", TargetRange: rng(1, 6, 1, 9), TargetSelectionRange: rng(1, 6, 1, 9)},
+		{TargetUri: "file:///b.ts", TargetRange: rng(1, 6, 1, 9), TargetSelectionRange: rng(1, 6, 1, 9)},
+	}
+	want := []protocol.LocationLink{links[0], links[2]}
+
+	if diff := cmp.Diff(want, dedupLocationLinks(links)); diff != "" {
+		t.Errorf("dedupLocationLinks() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDedupLocations(t *testing.T) {
+	locs := []protocol.Location{
+		{Uri: "file:///a.ts", Range: rng(1, 6, 1, 9)},
+		{Uri: "file:///a.vue", Range: rng(1, 6, 1, 9)},
+		{Uri: "file:///a.ts", Range: rng(1, 6, 1, 9)},
+		{Uri: "file:///a.ts", Range: rng(2, 6, 2, 9)},
+	}
+	want := []protocol.Location{locs[0], locs[1], locs[3]}
+
+	if diff := cmp.Diff(want, dedupLocations(locs)); diff != "" {
+		t.Errorf("dedupLocations() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func rng(l1, c1, l2, c2 uint32) protocol.Range {
+	return protocol.Range{Start: protocol.Position{Line: l1, Character: c1}, End: protocol.Position{Line: l2, Character: c2}}
+}
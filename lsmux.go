@@ -5,10 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
-	"strings"
 
 	"golang.org/x/exp/jsonrpc2"
+	"golang.org/x/sync/singleflight"
 )
 
 func Execute(ctx context.Context, cfg *Config) error {
@@ -20,7 +19,14 @@ func Execute(ctx context.Context, cfg *Config) error {
 	}
 	defer clientPipe.Close()
 
-	clientHandler := NewClientHandler(serverRegistry)
+	documents := NewDocumentStore()
+	partialResults := NewPartialResultRouter()
+	serverNames := make([]string, len(cfg.Servers))
+	for i, serverCfg := range cfg.Servers {
+		serverNames[i] = serverCfg.Name
+	}
+	diagRegistry := NewDiagnosticRegistry(cfg.Diagnostics, serverNames)
+	clientHandler := NewClientHandler(serverRegistry, documents, diagRegistry, partialResults, cfg)
 	clientBinder := NewMiddlewareBinder(NewBinder(clientHandler),
 		ContextLogMiddleware("ClientHandler"),
 		LoggingMiddleware(),
@@ -30,33 +36,32 @@ func Execute(ctx context.Context, cfg *Config) error {
 		return err
 	}
 	defer clientConn.Close()
+	clientHandler.SetClientConn(clientConn)
+	diagRegistry.SetClientConn(clientConn)
 
 	if len(cfg.Servers) == 0 {
 		return fmt.Errorf("no servers configured")
 	}
 
-	diagRegistry := NewDiagnosticRegistry()
+	refreshGroup := new(singleflight.Group)
 	for _, serverCfg := range cfg.Servers {
-		slog.InfoContext(ctx, fmt.Sprintf("starting lsp server: %s: %s", serverCfg.Name, strings.Join(append([]string{serverCfg.Command}, serverCfg.Args...), " ")))
-		serverPipe, err := NewCmdPipeListener(ctx, exec.CommandContext(ctx, serverCfg.Command, serverCfg.Args...))
-		if err != nil {
-			return err
-		}
-		defer serverPipe.Close()
-
-		serverHandler := NewServerHandler(serverCfg.Name, clientConn, diagRegistry)
+		server := NewServerConnection(serverCfg)
+		serverHandler := NewServerHandler(server, clientConn, documents, diagRegistry, cfg.Diagnostics, refreshGroup)
 		serverBinder := NewMiddlewareBinder(NewBinder(serverHandler),
 			ContextLogMiddleware("ServerHandler("+serverCfg.Name+")"),
 			LoggingMiddleware(),
 			NewVuelsTSServerRequestInterceptor(serverCfg.Name, serverRegistry).Handler,
+			partialResults.Handler,
 		)
-		serverConn, err := jsonrpc2.Dial(ctx, serverPipe.Dialer(), serverBinder)
-		if err != nil {
+		supervisor := NewServerSupervisor(serverCfg, server, serverBinder, clientConn, diagRegistry, clientHandler.RestoreServer)
+		if serverCfg.Lazy {
+			clientHandler.AddLazyServer(supervisor)
+		} else if err := supervisor.Start(ctx); err != nil {
 			return err
 		}
-		defer serverConn.Close()
+		defer server.Close()
 
-		serverRegistry.Add(ctx, serverCfg.Name, serverConn, serverCfg.InitializationOptions)
+		serverRegistry.Add(ctx, server)
 	}
 	slog.InfoContext(ctx, "lsmux started")
 
@@ -0,0 +1,85 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/exp/jsonrpc2"
+)
+
+const partialResultTokenPrefix = "lsmux/partialResult/"
+
+// PartialResultRouter intercepts partial results sent by servers with tokens created by lsmux.
+// It is used to merge partial results of multiple servers before they are sent to the client.
+type PartialResultRouter struct {
+	mu       sync.Mutex
+	seq      int
+	handlers map[string]func(ctx context.Context, value json.RawMessage) error
+}
+
+func NewPartialResultRouter() *PartialResultRouter {
+	return &PartialResultRouter{
+		handlers: make(map[string]func(ctx context.Context, value json.RawMessage) error),
+	}
+}
+
+// Register returns a new partial result token for the server, and a function to unregister it.
+// f is called with the value of each $/progress notification of the token.
+func (r *PartialResultRouter) Register(serverName string, f func(ctx context.Context, value json.RawMessage) error) (string, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	token := fmt.Sprintf("%s%s/%d", partialResultTokenPrefix, serverName, r.seq)
+	r.handlers[token] = f
+
+	return token, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		delete(r.handlers, token)
+	}
+}
+
+func (r *PartialResultRouter) handler(token string) (func(ctx context.Context, value json.RawMessage) error, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.handlers[token]
+	return f, ok
+}
+
+// Handler is a middleware for server connections.
+func (r *PartialResultRouter) Handler(next jsonrpc2.Handler) jsonrpc2.Handler {
+	f := func(ctx context.Context, req *jsonrpc2.Request) (any, error) {
+		if req.IsCall() || protocol.MethodKind(req.Method) != protocol.OptionalProgressMethod {
+			return next.Handle(ctx, req)
+		}
+
+		var params struct {
+			Token any             `json:"token"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		token, ok := params.Token.(string)
+		if !ok || !strings.HasPrefix(token, partialResultTokenPrefix) {
+			return next.Handle(ctx, req)
+		}
+
+		f, ok := r.handler(token)
+		if !ok {
+			// the request has already finished
+			slog.DebugContext(ctx, "drop partial result of finished request", "token", token)
+			return nil, nil
+		}
+		return nil, f(ctx, params.Value)
+	}
+	return jsonrpc2.HandlerFunc(f)
+}
@@ -0,0 +1,48 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/exp/jsonrpc2"
+)
+
+func TestPartialResultRouter(t *testing.T) {
+	router := NewPartialResultRouter()
+
+	var got []string
+	token, unregister := router.Register("eslint", func(ctx context.Context, value json.RawMessage) error {
+		got = append(got, string(value))
+		return nil
+	})
+
+	var forwarded []string
+	h := router.Handler(jsonrpc2.HandlerFunc(func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		forwarded = append(forwarded, string(r.Params))
+		return nil, nil
+	}))
+
+	progress := func(token any, value string) {
+		params, _ := json.Marshal(map[string]any{"token": token, "value": json.RawMessage(value)})
+		r, err := jsonrpc2.NewNotification("$/progress", json.RawMessage(params))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := h.Handle(context.Background(), r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	progress(token, `{"items":[]}`)
+	progress("client-token", `{"kind":"report"}`)
+	unregister()
+	progress(token, `{"items":[1]}`)
+
+	if len(got) != 1 || got[0] != `{"items":[]}` {
+		t.Errorf("partial results = %v, want one result of the token", got)
+	}
+	if len(forwarded) != 1 {
+		t.Errorf("forwarded = %v, want only the progress of the client token", forwarded)
+	}
+}
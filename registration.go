@@ -0,0 +1,78 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// dynamicOnlyMethods are notifications sent only to servers that registered them.
+var dynamicOnlyMethods = []string{
+	string(protocol.WorkspaceDidChangeWatchedFilesMethod),
+}
+
+// Registration is a capability registered by a server with client/registerCapability.
+type Registration struct {
+	Method string
+	// DocumentSelector restricts the documents of the registration. Nil means all documents.
+	DocumentSelector []DocumentFilter
+}
+
+func NewRegistration(method string, registerOptions any) Registration {
+	var options struct {
+		DocumentSelector []DocumentFilter `json:"documentSelector"`
+	}
+	// registerOptions is not always an object
+	if b, err := json.Marshal(registerOptions); err == nil {
+		json.Unmarshal(b, &options)
+	}
+	return Registration{Method: method, DocumentSelector: options.DocumentSelector}
+}
+
+func (r Registration) MatchDocument(doc Document) bool {
+	if r.DocumentSelector == nil {
+		return true
+	}
+	for _, f := range r.DocumentSelector {
+		if f.Match(doc) {
+			return true
+		}
+	}
+	return false
+}
+
+// DocumentFilter is a document filter of LSP. All specified fields must match.
+type DocumentFilter struct {
+	Language protocol.LanguageKind `json:"language"`
+	Scheme   string                `json:"scheme"`
+	// Pattern is a glob pattern or a relative pattern.
+	Pattern json.RawMessage `json:"pattern"`
+}
+
+func (f DocumentFilter) Match(doc Document) bool {
+	if f.Language != "" && f.Language != doc.LanguageID {
+		return false
+	}
+	if f.Scheme != "" && !strings.HasPrefix(string(doc.URI), f.Scheme+":") {
+		return false
+	}
+	if len(f.Pattern) != 0 {
+		var pattern string
+		if err := json.Unmarshal(f.Pattern, &pattern); err != nil {
+			// relative patterns are not supported, and match all documents
+			return true
+		}
+		g, err := CompileGlob(pattern)
+		if err != nil {
+			return false
+		}
+		return g.MatchURI(doc.URI)
+	}
+	return true
+}
+
+// registrationID returns the registration ID sent to the client, which is unique across servers.
+func registrationID(serverName string, id string) string {
+	return serverName + "/" + id
+}
@@ -0,0 +1,62 @@
+package lsmux
+
+import (
+	"testing"
+)
+
+func TestRegistration_MatchDocument(t *testing.T) {
+	tests := []struct {
+		name            string
+		registerOptions any
+		doc             Document
+		want            bool
+	}{
+		{
+			name:            "no options",
+			registerOptions: nil,
+			doc:             Document{URI: "file:///a.ts", LanguageID: "typescript"},
+			want:            true,
+		},
+		{
+			name:            "null selector",
+			registerOptions: map[string]any{"documentSelector": nil},
+			doc:             Document{URI: "file:///a.ts", LanguageID: "typescript"},
+			want:            true,
+		},
+		{
+			name:            "language",
+			registerOptions: map[string]any{"documentSelector": []any{map[string]any{"language": "vue"}}},
+			doc:             Document{URI: "file:///a.ts", LanguageID: "typescript"},
+			want:            false,
+		},
+		{
+			name:            "scheme and pattern",
+			registerOptions: map[string]any{"documentSelector": []any{map[string]any{"scheme": "file", "pattern": "**/*.ts"}}},
+			doc:             Document{URI: "file:///src/a.ts", LanguageID: "typescript"},
+			want:            true,
+		},
+		{
+			name:            "scheme mismatch",
+			registerOptions: map[string]any{"documentSelector": []any{map[string]any{"scheme": "untitled", "pattern": "**/*.ts"}}},
+			doc:             Document{URI: "file:///src/a.ts", LanguageID: "typescript"},
+			want:            false,
+		},
+		{
+			name: "any filter",
+			registerOptions: map[string]any{"documentSelector": []any{
+				map[string]any{"language": "vue"},
+				map[string]any{"language": "typescript"},
+			}},
+			doc:  Document{URI: "file:///a.ts", LanguageID: "typescript"},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := NewRegistration("textDocument/hover", tt.registerOptions)
+			if got := reg.MatchDocument(tt.doc); got != tt.want {
+				t.Errorf("MatchDocument() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package lsmux
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+type RoutingStrategyKind string
+
+const (
+	// RoutingFirst sends the request to the first capable server.
+	RoutingFirst RoutingStrategyKind = "first"
+	// RoutingAllMerge sends the request to all capable servers and merges the results.
+	RoutingAllMerge RoutingStrategyKind = "all-merge"
+	// RoutingFirstNonEmpty sends the request to all capable servers and returns the first non-empty result in server order.
+	RoutingFirstNonEmpty RoutingStrategyKind = "first-non-empty"
+)
+
+var routingStrategyKinds = []RoutingStrategyKind{RoutingFirst, RoutingAllMerge, RoutingFirstNonEmpty}
+
+// RoutingStrategy decides which servers receive a request and how their results are combined.
+//
+// It can be written as a strategy name (e.g. `first`) or as a mapping (e.g. `{priority: [ruff, pyright]}`).
+type RoutingStrategy struct {
+	Strategy RoutingStrategyKind `yaml:"strategy"`
+	// Priority lists servers that should be tried before the others.
+	Priority []string `yaml:"priority"`
+	// Exclude lists servers that never receive the request.
+	Exclude []string `yaml:"exclude"`
+}
+
+func (s *RoutingStrategy) UnmarshalYAML(b []byte) error {
+	var kind RoutingStrategyKind
+	if err := yaml.Unmarshal(b, &kind); err == nil {
+		*s = RoutingStrategy{Strategy: kind}
+		return nil
+	}
+
+	type alias RoutingStrategy
+	var v alias
+	if err := yaml.UnmarshalWithOptions(b, &v, yaml.DisallowUnknownField()); err != nil {
+		return err
+	}
+	*s = RoutingStrategy(v)
+	return nil
+}
+
+func (s RoutingStrategy) validate(serverNames []string) error {
+	if s.Strategy != "" && !slices.Contains(routingStrategyKinds, s.Strategy) {
+		return fmt.Errorf("unknown strategy: %s", s.Strategy)
+	}
+	for _, name := range slices.Concat(s.Priority, s.Exclude) {
+		if !slices.Contains(serverNames, name) {
+			return fmt.Errorf("server not found in config: %s", name)
+		}
+	}
+	return nil
+}
+
+// Kind returns the strategy kind, defaulting to RoutingFirst.
+func (s RoutingStrategy) Kind() RoutingStrategyKind {
+	return cmp.Or(s.Strategy, RoutingFirst)
+}
+
+// Select returns servers without excluded ones, ordered by priority.
+func (s RoutingStrategy) Select(servers ServerConnectionList) ServerConnectionList {
+	var res ServerConnectionList
+	for _, name := range s.Priority {
+		if server, found := servers.FindByName(name); found && !slices.Contains(s.Exclude, name) {
+			res = append(res, server)
+		}
+	}
+	for _, server := range servers {
+		if !slices.Contains(s.Priority, server.Name) && !slices.Contains(s.Exclude, server.Name) {
+			res = append(res, server)
+		}
+	}
+	return res
+}
+
+// RoutingTable maps LSP method names or glob patterns (e.g. `textDocument/*`) to routing strategies.
+type RoutingTable map[string]RoutingStrategy
+
+// Lookup returns the strategy for method.
+// An exact method name is preferred, then the longest matching pattern.
+func (t RoutingTable) Lookup(method string) (RoutingStrategy, bool) {
+	if s, ok := t[method]; ok {
+		return s, true
+	}
+
+	var patterns []string
+	for pattern := range t {
+		if matched, _ := path.Match(pattern, method); matched {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return RoutingStrategy{}, false
+	}
+
+	slices.SortFunc(patterns, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	return t[patterns[0]], true
+}
+
+func (t RoutingTable) validate(serverNames []string) error {
+	for pattern, s := range t {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("routing[%s]: invalid pattern: %w", pattern, err)
+		}
+		if err := s.validate(serverNames); err != nil {
+			return fmt.Errorf("routing[%s]: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// defaultRouting is used when no routing is configured for a method.
+var defaultRouting = RoutingTable{
+	"textDocument/completion":     {Strategy: RoutingAllMerge},
+	"textDocument/codeAction":     {Strategy: RoutingAllMerge},
+	"textDocument/definition":     {Strategy: RoutingAllMerge},
+	"textDocument/declaration":    {Strategy: RoutingAllMerge},
+	"textDocument/typeDefinition": {Strategy: RoutingAllMerge},
+	"textDocument/implementation": {Strategy: RoutingAllMerge},
+	"textDocument/references":     {Strategy: RoutingAllMerge},
+	"textDocument/hover":          {Strategy: RoutingAllMerge},
+	"textDocument/documentSymbol": {Strategy: RoutingAllMerge},
+	"workspace/symbol":            {Strategy: RoutingAllMerge},
+	"textDocument/codeLens":       {Strategy: RoutingAllMerge},
+	"textDocument/inlayHint":      {Strategy: RoutingAllMerge},
+	"textDocument/diagnostic":     {Strategy: RoutingAllMerge},
+	"workspace/diagnostic":        {Strategy: RoutingAllMerge},
+}
+
+// lookupRouting returns the configured strategy for method.
+// The default strategy kind is used if the configured one does not specify it.
+func lookupRouting(routing RoutingTable, method string) RoutingStrategy {
+	s, _ := routing.Lookup(method)
+	if s.Strategy == "" {
+		s.Strategy = lookupDefaultRouting(method)
+	}
+	return s
+}
+
+func lookupDefaultRouting(method string) RoutingStrategyKind {
+	s, _ := defaultRouting.Lookup(method)
+	return s.Kind()
+}
@@ -0,0 +1,81 @@
+package lsmux
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRoutingTable_Lookup(t *testing.T) {
+	table := RoutingTable{
+		"textDocument/hover":            {Strategy: RoutingFirstNonEmpty},
+		"textDocument/*":                {Strategy: RoutingAllMerge},
+		"textDocument/semanticTokens/*": {Strategy: RoutingFirst},
+		"*/*":                           {Exclude: []string{"eslint"}},
+	}
+
+	tests := []struct {
+		method string
+		want   RoutingStrategy
+		found  bool
+	}{
+		{method: "textDocument/hover", want: RoutingStrategy{Strategy: RoutingFirstNonEmpty}, found: true},
+		{method: "textDocument/definition", want: RoutingStrategy{Strategy: RoutingAllMerge}, found: true},
+		{method: "textDocument/semanticTokens/full", want: RoutingStrategy{Strategy: RoutingFirst}, found: true},
+		{method: "workspace/symbol", want: RoutingStrategy{Exclude: []string{"eslint"}}, found: true},
+		{method: "initialize", found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			got, found := table.Lookup(tt.method)
+			if found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Lookup() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLookupRouting(t *testing.T) {
+	table := RoutingTable{
+		"textDocument/*": {Exclude: []string{"eslint"}},
+	}
+
+	if got, want := lookupRouting(table, "textDocument/completion"), (RoutingStrategy{Strategy: RoutingAllMerge, Exclude: []string{"eslint"}}); !cmp.Equal(want, got) {
+		t.Errorf("lookupRouting() = %v, want %v", got, want)
+	}
+	if got, want := lookupRouting(table, "textDocument/rename"), (RoutingStrategy{Strategy: RoutingFirst, Exclude: []string{"eslint"}}); !cmp.Equal(want, got) {
+		t.Errorf("lookupRouting() = %v, want %v", got, want)
+	}
+}
+
+func TestRoutingStrategy_Select(t *testing.T) {
+	servers := ServerConnectionList{{Name: "tsls"}, {Name: "vuels"}, {Name: "eslint"}}
+	names := func(l ServerConnectionList) []string {
+		var res []string
+		for _, s := range l {
+			res = append(res, s.Name)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name     string
+		strategy RoutingStrategy
+		want     []string
+	}{
+		{name: "default", strategy: RoutingStrategy{}, want: []string{"tsls", "vuels", "eslint"}},
+		{name: "priority", strategy: RoutingStrategy{Priority: []string{"eslint", "vuels"}}, want: []string{"eslint", "vuels", "tsls"}},
+		{name: "exclude", strategy: RoutingStrategy{Exclude: []string{"tsls"}}, want: []string{"vuels", "eslint"}},
+		{name: "priority and exclude", strategy: RoutingStrategy{Priority: []string{"eslint", "tsls"}, Exclude: []string{"eslint"}}, want: []string{"tsls", "vuels"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, names(tt.strategy.Select(servers))); diff != "" {
+				t.Errorf("Select() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -3,8 +3,11 @@ package lsmux
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"slices"
+	"sync"
 
 	"github.com/buzztaiki/lsmux/capability"
 	"github.com/myleshyson/lsprotocol-go/protocol"
@@ -12,11 +15,181 @@ import (
 )
 
 type ServerConnection struct {
-	Name                  string
+	Name         string
+	InitOptions  map[string]any
+	Languages    []protocol.LanguageKind
+	FilePatterns []Glob
+	// Lazy means the server is started on the first document matching Languages or FilePatterns.
+	Lazy bool
+	// Diagnostics rewrites push and pull diagnostics of the server.
+	Diagnostics ServerDiagnosticsConfig
+
+	mu                    sync.Mutex
 	conn                  *jsonrpc2.Connection
-	InitOptions           map[string]any
-	SupportedCapabilities capability.SupportedSet
-	Capabilities          *protocol.ServerCapabilities
+	connCtx               context.Context // canceled when the server process exits
+	cancel                context.CancelCauseFunc
+	ready                 bool // the client session is restored to the connection
+	stopped               bool
+	registrations         map[string]Registration // by registration ID of the server
+	capabilities          *protocol.ServerCapabilities
+	supportedCapabilities capability.SupportedSet
+}
+
+func NewServerConnection(cfg ServerConfig) *ServerConnection {
+	return &ServerConnection{
+		Name:         cfg.Name,
+		InitOptions:  cfg.InitializationOptions,
+		Languages:    cfg.Languages,
+		FilePatterns: cfg.FilePatterns,
+		Lazy:         cfg.Lazy,
+		Diagnostics:  cfg.Diagnostics,
+	}
+}
+
+// SetCapabilities replaces the capabilities of the server, which are changed when the server is restarted.
+func (c *ServerConnection) SetCapabilities(caps *protocol.ServerCapabilities, supported capability.SupportedSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capabilities = caps
+	c.supportedCapabilities = supported
+}
+
+func (c *ServerConnection) isSupportedCapability(method string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.supportedCapabilities.IsSupportedMethod(method)
+}
+
+// SupportsCommand reports whether the server can execute the command.
+func (c *ServerConnection) SupportsCommand(command string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.capabilities != nil && c.capabilities.ExecuteCommandProvider != nil &&
+		slices.Contains(c.capabilities.ExecuteCommandProvider.Commands, command)
+}
+
+// Register records a dynamic registration of the server.
+func (c *ServerConnection) Register(id string, reg Registration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.registrations == nil {
+		c.registrations = make(map[string]Registration)
+	}
+	c.registrations[id] = reg
+}
+
+func (c *ServerConnection) Unregister(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.registrations, id)
+}
+
+// ClearRegistrations removes all dynamic registrations and returns them.
+func (c *ServerConnection) ClearRegistrations() map[string]Registration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	regs := c.registrations
+	c.registrations = nil
+	return regs
+}
+
+// IsRegisteredMethod reports whether the server registered the method dynamically for the document.
+// A nil document matches any registration of the method.
+func (c *ServerConnection) IsRegisteredMethod(method string, doc *Document) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, reg := range c.registrations {
+		if reg.Method == method && (doc == nil || reg.MatchDocument(*doc)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsMethod reports whether the server supports the method by its capabilities or dynamic registrations.
+func (c *ServerConnection) SupportsMethod(method string) bool {
+	if slices.Contains(dynamicOnlyMethods, method) {
+		return c.IsRegisteredMethod(method, nil)
+	}
+	return c.isSupportedCapability(method) || c.IsRegisteredMethod(method, nil)
+}
+
+// Started reports whether the server process has been started.
+func (c *ServerConnection) Started() bool {
+	conn, _ := c.connection()
+	return conn != nil
+}
+
+// Ready reports whether the server is started and ready to receive messages of the client.
+// A restarted server is not ready until the client session is restored.
+func (c *ServerConnection) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn != nil && c.ready
+}
+
+// SetReady marks the server ready to receive messages of the client.
+func (c *ServerConnection) SetReady() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ready = true
+}
+
+// MatchDocument reports whether the document should be sent to the server.
+func (c *ServerConnection) MatchDocument(doc Document) bool {
+	if len(c.Languages) == 0 && len(c.FilePatterns) == 0 {
+		return true
+	}
+	if slices.Contains(c.Languages, doc.LanguageID) {
+		return true
+	}
+	return slices.ContainsFunc(c.FilePatterns, func(g Glob) bool { return g.MatchURI(doc.URI) })
+}
+
+// connection returns the current connection, which is replaced when the server is restarted.
+func (c *ServerConnection) connection() (*jsonrpc2.Connection, context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn, c.connCtx
+}
+
+func (c *ServerConnection) setConnection(conn *jsonrpc2.Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = conn
+	c.connCtx, c.cancel = context.WithCancelCause(context.Background())
+	c.ready = false
+}
+
+// resetConnection forgets the connection, so that the server is regarded as not started.
+func (c *ServerConnection) resetConnection() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = nil
+	c.ready = false
+}
+
+// disconnect fails the pending requests of conn, since jsonrpc2 does not fail them when the stream is closed.
+func (c *ServerConnection) disconnect(conn *jsonrpc2.Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == conn {
+		c.cancel(fmt.Errorf("server exited: %s", c.Name))
+		c.ready = false
+	}
 }
 
 func (c *ServerConnection) CallWithRawResult(ctx context.Context, method string, params any) (json.RawMessage, error) {
@@ -29,16 +202,87 @@ func (c *ServerConnection) CallWithRawResult(ctx context.Context, method string,
 
 func (c *ServerConnection) Call(ctx context.Context, method string, params any, res any) error {
 	slog.DebugContext(ctx, "send request to "+c.Name, "method", method)
-	return c.conn.Call(ctx, method, params).Await(ctx, &res)
+	conn, connCtx := c.connection()
+	if conn == nil {
+		return fmt.Errorf("server not started: %s", c.Name)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(connCtx, func() { cancel(context.Cause(connCtx)) })
+	defer stop()
+
+	if err := conn.Call(ctx, method, params).Await(ctx, &res); err != nil {
+		if connCtx.Err() != nil {
+			return context.Cause(connCtx)
+		}
+		return err
+	}
+	return nil
 }
 
 func (c *ServerConnection) Notify(ctx context.Context, method string, params any) error {
 	slog.DebugContext(ctx, "notify to "+c.Name, "method", method)
-	return c.conn.Notify(ctx, method, params)
+	conn, _ := c.connection()
+	if conn == nil {
+		return fmt.Errorf("server not started: %s", c.Name)
+	}
+	return conn.Notify(ctx, method, params)
+}
+
+// Stop marks the server as intentionally stopped, so that its exit is not treated as a crash.
+func (c *ServerConnection) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+}
+
+func (c *ServerConnection) Stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stopped
 }
 
 func (c *ServerConnection) Close() error {
-	return c.conn.Close()
+	c.Stop()
+	conn, _ := c.connection()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// CallServers sends the request to servers concurrently and stores each result in the corresponding element of results.
+// A server that fails is logged and its result is left as the zero value. An error is returned only if all servers fail.
+func CallServers[T any](ctx context.Context, servers ServerConnectionList, method string, params any, results []T) error {
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = server.Call(ctx, method, params, &results[i])
+		}()
+	}
+	wg.Wait()
+	return joinServerErrors(ctx, servers, method, errs)
+}
+
+// joinServerErrors logs errors of servers, and returns them joined only if all servers fail.
+func joinServerErrors(ctx context.Context, servers ServerConnectionList, method string, errs []error) error {
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			slog.WarnContext(ctx, "server request failed", "server", servers[i].Name, "method", method, "error", err)
+		}
+	}
+	if failed != 0 && failed == len(servers) {
+		return errors.Join(errs...)
+	}
+	return nil
 }
 
 type ServerConnectionRegistry struct {
@@ -55,13 +299,9 @@ func NewServerConnectionRegistry(nservers int) *ServerConnectionRegistry {
 	}
 }
 
-func (r *ServerConnectionRegistry) Add(ctx context.Context, name string, conn *jsonrpc2.Connection, initOptions map[string]any) {
+func (r *ServerConnectionRegistry) Add(ctx context.Context, server *ServerConnection) {
 	if len(r.servers) < r.nservers {
-		r.servers = append(r.servers, &ServerConnection{
-			Name:        name,
-			conn:        conn,
-			InitOptions: initOptions,
-		})
+		r.servers = append(r.servers, server)
 	}
 	if len(r.servers) == r.nservers {
 		close(r.ready)
@@ -76,10 +316,55 @@ func (r *ServerConnectionRegistry) Servers() ServerConnectionList {
 	return r.servers
 }
 
+func (l ServerConnectionList) FilterByReady() ServerConnectionList {
+	servers := []*ServerConnection{}
+	for _, s := range l {
+		if s.Ready() {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
 func (l ServerConnectionList) FilterBySupportedMethod(method string) ServerConnectionList {
 	servers := []*ServerConnection{}
 	for _, s := range l {
-		if s.SupportedCapabilities.IsSupportedMethod(method) {
+		if s.SupportsMethod(method) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// FilterByDocument returns servers that receive the method for the document.
+// Dynamic registrations of the method are respected if the server does not support it by its capabilities.
+func (l ServerConnectionList) FilterByDocument(method string, doc Document) ServerConnectionList {
+	servers := []*ServerConnection{}
+	for _, s := range l {
+		if !s.MatchDocument(doc) {
+			continue
+		}
+		if s.isSupportedCapability(method) || s.IsRegisteredMethod(method, &doc) {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
+
+// DiagnosticsConfigs returns the diagnostics configs of the servers by their names.
+func (l ServerConnectionList) DiagnosticsConfigs() map[string]ServerDiagnosticsConfig {
+	configs := make(map[string]ServerDiagnosticsConfig, len(l))
+	for _, s := range l {
+		configs[s.Name] = s.Diagnostics
+	}
+	return configs
+}
+
+// FilterByNames returns servers whose names are in names.
+func (l ServerConnectionList) FilterByNames(names []string) ServerConnectionList {
+	servers := []*ServerConnection{}
+	for _, s := range l {
+		if slices.Contains(names, s.Name) {
 			servers = append(servers, s)
 		}
 	}
@@ -95,11 +380,7 @@ func (l ServerConnectionList) FindByName(name string) (*ServerConnection, bool)
 }
 
 func (l ServerConnectionList) FindByCommand(command string) (*ServerConnection, bool) {
-	commandSupported := func(s *ServerConnection) bool {
-		return slices.Index(s.Capabilities.ExecuteCommandProvider.Commands, command) != -1
-	}
-
-	i := slices.IndexFunc(l, commandSupported)
+	i := slices.IndexFunc(l, func(s *ServerConnection) bool { return s.SupportsCommand(command) })
 	if i == -1 {
 		return nil, false
 	}
@@ -0,0 +1,77 @@
+package lsmux
+
+import (
+	"testing"
+
+	"github.com/buzztaiki/lsmux/capability"
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestServerConnectionList_FilterByDocument(t *testing.T) {
+	servers := ServerConnectionList{
+		{Name: "tsls", Languages: []protocol.LanguageKind{"typescript", "javascript"}},
+		{Name: "vuels", Languages: []protocol.LanguageKind{"vue"}, FilePatterns: []Glob{MustCompileGlob("**/*.vue")}},
+		{Name: "eslint"},
+	}
+
+	tests := []struct {
+		name string
+		doc  Document
+		want []string
+	}{
+		{name: "language", doc: Document{URI: "file:///src/a.ts", LanguageID: "typescript"}, want: []string{"tsls", "eslint"}},
+		{name: "pattern", doc: Document{URI: "file:///src/App.vue"}, want: []string{"vuels", "eslint"}},
+		{name: "no match", doc: Document{URI: "file:///README.md", LanguageID: "markdown"}, want: []string{"eslint"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, s := range servers.FilterByDocument("textDocument/didOpen", tt.doc) {
+				got = append(got, s.Name)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("FilterByDocument() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServerConnection_SupportsMethod(t *testing.T) {
+	server := &ServerConnection{Name: "eslint"}
+	server.SetCapabilities(&protocol.ServerCapabilities{}, capability.SupportedSet{})
+	server.Register("1", NewRegistration("textDocument/hover", map[string]any{
+		"documentSelector": []any{map[string]any{"language": "typescript"}},
+	}))
+	server.Register("2", NewRegistration("workspace/didChangeWatchedFiles", nil))
+
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{method: "textDocument/hover", want: true},
+		{method: "textDocument/definition", want: false},
+		{method: "workspace/didChangeWatchedFiles", want: true},
+		{method: "textDocument/didOpen", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			if got := server.SupportsMethod(tt.method); got != tt.want {
+				t.Errorf("SupportsMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	servers := ServerConnectionList{server}
+	if got := servers.FilterByDocument("textDocument/hover", Document{URI: "file:///a.vue", LanguageID: "vue"}); len(got) != 0 {
+		t.Errorf("FilterByDocument() = %v, want no servers for unregistered document", got)
+	}
+	if got := servers.FilterByDocument("textDocument/hover", Document{URI: "file:///a.ts", LanguageID: "typescript"}); len(got) != 1 {
+		t.Errorf("FilterByDocument() = %v, want the registered server", got)
+	}
+
+	server.Unregister("2")
+	if server.SupportsMethod("workspace/didChangeWatchedFiles") {
+		t.Error("unregistered method is supported")
+	}
+}
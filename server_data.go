@@ -0,0 +1,47 @@
+package lsmux
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// Keys of the data field wrapped by wrapServerData.
+const (
+	serverDataServerKey       = "lsmux.server"
+	serverDataOriginalDataKey = "lsmux.originalData"
+)
+
+// resolvableMethods are methods whose result items are resolved by the server that produced them.
+var resolvableMethods = []protocol.MethodKind{
+	protocol.TextDocumentCompletionMethod,
+	protocol.TextDocumentCodeActionMethod,
+	protocol.WorkspaceSymbolMethod,
+	protocol.TextDocumentCodeLensMethod,
+	protocol.TextDocumentInlayHintMethod,
+}
+
+// isResolvableMethod reports whether items of the method result should be wrapped by wrapServerData.
+func isResolvableMethod(method string) bool {
+	return slices.Contains(resolvableMethods, protocol.MethodKind(method))
+}
+
+// wrapServerData wraps the data field of an item (e.g. code action or completion item) with the name of the server that produced it,
+// so that the item can be routed back to the server on resolve.
+func wrapServerData(serverName string, data any) map[string]any {
+	return map[string]any{serverDataServerKey: serverName, serverDataOriginalDataKey: data}
+}
+
+// unwrapServerData extracts the server name and original data from data wrapped by wrapServerData.
+func unwrapServerData(data any) (string, any, error) {
+	kv, ok := data.(map[string]any)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid data: %v", data)
+	}
+	serverName, ok := kv[serverDataServerKey].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("%s not found in data", serverDataServerKey)
+	}
+	return serverName, kv[serverDataOriginalDataKey], nil
+}
@@ -3,22 +3,32 @@ package lsmux
 import (
 	"context"
 	"encoding/json"
+	"log/slog"
 
 	"github.com/myleshyson/lsprotocol-go/protocol"
 	"golang.org/x/exp/jsonrpc2"
+	"golang.org/x/sync/singleflight"
 )
 
 type ServerHandler struct {
-	name         string
+	server       *ServerConnection
 	clientConn   *jsonrpc2.Connection
+	documents    *DocumentStore
 	diagRegistry *DiagnosticRegistry
+	diagCfg      DiagnosticsConfig
+	refreshGroup *singleflight.Group
 }
 
-func NewServerHandler(name string, clientConn *jsonrpc2.Connection, diagRegistry *DiagnosticRegistry) *ServerHandler {
+// NewServerHandler creates a handler for requests from the server.
+// refreshGroup should be shared by all servers to coalesce refresh requests.
+func NewServerHandler(server *ServerConnection, clientConn *jsonrpc2.Connection, documents *DocumentStore, diagRegistry *DiagnosticRegistry, diagCfg DiagnosticsConfig, refreshGroup *singleflight.Group) *ServerHandler {
 	return &ServerHandler{
-		name:         name,
+		server:       server,
 		clientConn:   clientConn,
+		documents:    documents,
 		diagRegistry: diagRegistry,
+		diagCfg:      diagCfg,
+		refreshGroup: refreshGroup,
 	}
 }
 
@@ -34,6 +44,21 @@ func (h *ServerHandler) Handle(ctx context.Context, r *jsonrpc2.Request) (any, e
 		}
 	}
 
+	switch method {
+	case protocol.WorkspaceCodeLensRefreshMethod,
+		protocol.WorkspaceInlayHintRefreshMethod,
+		protocol.WorkspaceDiagnosticRefreshMethod:
+		return h.handleRefreshRequest(ctx, r)
+	case protocol.ClientRegisterCapabilityMethod:
+		return h.handleRegisterCapabilityRequest(ctx, r)
+	case protocol.ClientUnregisterCapabilityMethod:
+		return h.handleUnregisterCapabilityRequest(ctx, r)
+	default:
+		return h.callClient(ctx, r)
+	}
+}
+
+func (h *ServerHandler) callClient(ctx context.Context, r *jsonrpc2.Request) (json.RawMessage, error) {
 	var res json.RawMessage
 	if err := h.clientConn.Call(ctx, r.Method, r.Params).Await(ctx, &res); err != nil {
 		return nil, err
@@ -41,14 +66,88 @@ func (h *ServerHandler) Handle(ctx context.Context, r *jsonrpc2.Request) (any, e
 	return res, nil
 }
 
+// handleRefreshRequest forwards refresh requests sent from multiple servers at the same time to the client once.
+func (h *ServerHandler) handleRefreshRequest(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+	res, err, _ := h.refreshGroup.Do(r.Method, func() (any, error) {
+		// other servers waiting for the shared call must not fail when this request is canceled
+		return h.callClient(context.WithoutCancel(ctx), r)
+	})
+	return res, err
+}
+
+// handleRegisterCapabilityRequest records the registrations and forwards them to the client with IDs unique across servers.
+func (h *ServerHandler) handleRegisterCapabilityRequest(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+	var params protocol.RegistrationParams
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(params.Registrations))
+	for i, reg := range params.Registrations {
+		ids[i] = reg.Id
+		h.server.Register(reg.Id, NewRegistration(reg.Method, reg.RegisterOptions))
+		params.Registrations[i].Id = registrationID(h.server.Name, reg.Id)
+	}
+
+	var res json.RawMessage
+	if err := h.clientConn.Call(ctx, r.Method, params).Await(ctx, &res); err != nil {
+		for _, id := range ids {
+			h.server.Unregister(id)
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (h *ServerHandler) handleUnregisterCapabilityRequest(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+	var params protocol.UnregistrationParams
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, err
+	}
+
+	for i, unreg := range params.Unregisterations {
+		h.server.Unregister(unreg.Id)
+		params.Unregisterations[i].Id = registrationID(h.server.Name, unreg.Id)
+	}
+
+	var res json.RawMessage
+	if err := h.clientConn.Call(ctx, r.Method, params).Await(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (h *ServerHandler) handlePublishDiagnosticsNotification(ctx context.Context, r *jsonrpc2.Request) error {
 	var params protocol.PublishDiagnosticsParams
 	if err := json.Unmarshal(r.Params, &params); err != nil {
 		return err
 	}
 
-	h.diagRegistry.UpdateDiagnostics(params.Uri, h.name, params.Diagnostics)
-	params.Diagnostics = h.diagRegistry.GetDiagnostics(params.Uri)
+	diags := h.server.Diagnostics.Apply(params.Uri, params.Diagnostics)
+	version := h.documents.ClientVersion(params.Uri, params.Version)
+	if !h.diagRegistry.UpdateDiagnostics(params.Uri, h.server.Name, version, diags) {
+		slog.DebugContext(ctx, "drop diagnostics of old document version", "server", h.server.Name, "uri", params.Uri, "version", params.Version)
+		return nil
+	}
 
-	return h.clientConn.Notify(ctx, r.Method, params)
+	// the client pulls the updated diagnostics in pull mode
+	if h.diagCfg.Mode == DiagnosticModePull {
+		go h.refreshDiagnostics(context.WithoutCancel(ctx))
+		return nil
+	}
+
+	h.diagRegistry.Publish(ctx, params.Uri)
+	return nil
+}
+
+// refreshDiagnostics asks the client to pull diagnostics again if the client supports it.
+// It does not block the server handler, since the client may pull diagnostics from this server while refreshing.
+func (h *ServerHandler) refreshDiagnostics(ctx context.Context) {
+	method := string(protocol.WorkspaceDiagnosticRefreshMethod)
+	_, err, _ := h.refreshGroup.Do(method, func() (any, error) {
+		return nil, h.diagRegistry.Refresh(ctx)
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to refresh diagnostics", "server", h.server.Name, "error", err)
+	}
 }
@@ -0,0 +1,56 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/jsonrpc2"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+)
+
+func TestServerHandlerRefreshRequest(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	clientConn := newTestConnection(t, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return json.RawMessage("null"), nil
+	})
+
+	refreshGroup := new(singleflight.Group)
+	newHandler := func(name string) *ServerHandler {
+		return NewServerHandler(&ServerConnection{Name: name}, clientConn, NewDocumentStore(), nil, DiagnosticsConfig{}, refreshGroup)
+	}
+	refresh := func(ctx context.Context, h *ServerHandler) error {
+		r, err := jsonrpc2.NewCall(jsonrpc2.Int64ID(1), "workspace/codeLens/refresh", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = h.Handle(ctx, r)
+		return err
+	}
+
+	// the first request is canceled while the second one waits for the shared call
+	ctx, cancel := context.WithCancel(context.Background())
+	var g errgroup.Group
+	g.Go(func() error { return refresh(ctx, newHandler("ruff")) })
+	<-started
+	g.Go(func() error { return refresh(context.Background(), newHandler("pyright")) })
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+
+	if err := g.Wait(); err != nil {
+		t.Errorf("refresh error = %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("refresh calls to the client = %d, want 1", got)
+	}
+}
@@ -0,0 +1,195 @@
+package lsmux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/exp/jsonrpc2"
+)
+
+var (
+	restartBackoffBase = time.Second
+	restartBackoffMax  = 30 * time.Second
+	// restartResetAfter resets the restart count if the server has been running longer than this.
+	restartResetAfter = 5 * time.Minute
+)
+
+// ServerSupervisor runs a server process and restarts it when the process exits unexpectedly.
+type ServerSupervisor struct {
+	cfg         ServerConfig
+	server      *ServerConnection
+	binder      jsonrpc2.Binder
+	clientConn  *jsonrpc2.Connection
+	diagnostics *DiagnosticRegistry
+	restore     func(ctx context.Context, server *ServerConnection) error
+	startedAt   time.Time
+	restarts    int
+}
+
+// NewServerSupervisor creates a supervisor of the server.
+// restore is called after the server is started to restore the client session (e.g. initialize and open documents),
+// and it should mark the server ready.
+// The diagnostics of the server are removed from diagnostics when the server exits.
+func NewServerSupervisor(cfg ServerConfig, server *ServerConnection, binder jsonrpc2.Binder, clientConn *jsonrpc2.Connection, diagnostics *DiagnosticRegistry, restore func(ctx context.Context, server *ServerConnection) error) *ServerSupervisor {
+	return &ServerSupervisor{
+		cfg:         cfg,
+		server:      server,
+		binder:      binder,
+		clientConn:  clientConn,
+		diagnostics: diagnostics,
+		restore:     restore,
+	}
+}
+
+// Start starts the server process, restores the client session to it, and restarts it when it exits unexpectedly.
+func (s *ServerSupervisor) Start(ctx context.Context) error {
+	exited, err := s.start(ctx)
+	if err != nil {
+		// the next start retries it (e.g. by the next matching document of a lazy server)
+		s.server.resetConnection()
+		return err
+	}
+	go s.watch(ctx, exited)
+	return nil
+}
+
+// start starts the server process and restores the client session to it.
+// The returned channel receives the exit error of the process after its connection is closed.
+func (s *ServerSupervisor) start(ctx context.Context) (<-chan error, error) {
+	slog.InfoContext(ctx, fmt.Sprintf("starting lsp server: %s: %s", s.cfg.Name, strings.Join(append([]string{s.cfg.Command}, s.cfg.Args...), " ")))
+
+	cmd := exec.CommandContext(ctx, s.cfg.Command, s.cfg.Args...)
+	pipe, stdoutDone, err := NewCmdPipeListener(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := jsonrpc2.Dial(ctx, pipe.Dialer(), s.binder)
+	if err != nil {
+		pipe.Close()
+		cmd.Process.Kill()
+		<-stdoutDone
+		cmd.Wait()
+		return nil, err
+	}
+
+	s.server.setConnection(conn)
+	s.startedAt = time.Now()
+	exited := make(chan error, 1)
+	go func() {
+		// cmd.Wait closes stdout, so wait until the remaining output is read
+		<-stdoutDone
+		err := cmd.Wait()
+		s.server.disconnect(conn)
+		conn.Close()
+		pipe.Close()
+		exited <- err
+	}()
+
+	if err := s.restore(ctx, s.server); err != nil {
+		cmd.Process.Kill()
+		<-exited
+		s.clearServerState(ctx)
+		return nil, fmt.Errorf("failed to restore lsp server: %w", err)
+	}
+	return exited, nil
+}
+
+// watch waits for the exit of the server process, and restarts it if the exit is unexpected.
+func (s *ServerSupervisor) watch(ctx context.Context, exited <-chan error) {
+	for exited != nil {
+		err := <-exited
+
+		log := slog.With("server", s.cfg.Name)
+		if s.server.Stopped() || ctx.Err() != nil {
+			s.diagnostics.RemoveServer(s.cfg.Name)
+			log.InfoContext(ctx, "lsp server exited", "error", err)
+			return
+		}
+		log.WarnContext(ctx, "lsp server exited unexpectedly", "error", err)
+		s.clearServerState(ctx)
+
+		if time.Since(s.startedAt) >= restartResetAfter {
+			s.restarts = 0
+		}
+		exited = s.restart(ctx)
+	}
+}
+
+// restart restarts the server with backoff until it succeeds or reaches the restart limit.
+// It returns nil if the server is not restarted.
+func (s *ServerSupervisor) restart(ctx context.Context) <-chan error {
+	log := slog.With("server", s.cfg.Name)
+	for {
+		if s.restarts >= s.cfg.MaxRestarts {
+			s.showMessage(ctx, protocol.MessageTypeError, fmt.Sprintf("%s exited unexpectedly and reached the restart limit (%d)", s.cfg.Name, s.cfg.MaxRestarts))
+			return nil
+		}
+
+		s.restarts++
+		backoff := min(restartBackoffBase<<(s.restarts-1), restartBackoffMax)
+		s.showMessage(ctx, protocol.MessageTypeWarning, fmt.Sprintf("%s exited unexpectedly, restarting in %s (%d/%d)", s.cfg.Name, backoff, s.restarts, s.cfg.MaxRestarts))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		if s.server.Stopped() {
+			return nil
+		}
+
+		exited, err := s.start(ctx)
+		if err != nil {
+			log.WarnContext(ctx, "failed to restart lsp server", "error", err)
+			continue
+		}
+
+		s.showMessage(ctx, protocol.MessageTypeInfo, fmt.Sprintf("%s restarted", s.cfg.Name))
+		return exited
+	}
+}
+
+// clearServerState removes diagnostics and dynamic registrations of the exited server from the client.
+func (s *ServerSupervisor) clearServerState(ctx context.Context) {
+	uris := s.diagnostics.RemoveServer(s.cfg.Name)
+	s.unregisterCapabilities(ctx)
+	for _, uri := range uris {
+		s.diagnostics.Publish(ctx, uri)
+	}
+	// the client pulls diagnostics without the server in pull mode
+	if err := s.diagnostics.Refresh(ctx); err != nil {
+		slog.WarnContext(ctx, "failed to refresh diagnostics", "server", s.cfg.Name, "error", err)
+	}
+}
+
+// unregisterCapabilities unregisters dynamic registrations of the exited server from the client.
+func (s *ServerSupervisor) unregisterCapabilities(ctx context.Context) {
+	regs := s.server.ClearRegistrations()
+	if len(regs) == 0 {
+		return
+	}
+
+	var params protocol.UnregistrationParams
+	for id, reg := range regs {
+		params.Unregisterations = append(params.Unregisterations, protocol.Unregistration{
+			Id:     registrationID(s.cfg.Name, id),
+			Method: reg.Method,
+		})
+	}
+	if err := s.clientConn.Call(ctx, string(protocol.ClientUnregisterCapabilityMethod), params).Await(ctx, nil); err != nil {
+		slog.WarnContext(ctx, "failed to unregister capabilities", "server", s.cfg.Name, "error", err)
+	}
+}
+
+func (s *ServerSupervisor) showMessage(ctx context.Context, typ protocol.MessageType, message string) {
+	params := protocol.ShowMessageParams{Type: typ, Message: "lsmux: " + message}
+	if err := s.clientConn.Notify(ctx, string(protocol.WindowShowMessageMethod), params); err != nil {
+		slog.WarnContext(ctx, "failed to show message", "error", err)
+	}
+}
@@ -0,0 +1,245 @@
+package lsmux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+	"golang.org/x/exp/jsonrpc2"
+)
+
+const helperServerEnv = "LSMUX_TEST_HELPER_SERVER"
+
+// TestHelperServer is not a test, but a language server run as a child process by the supervisor tests.
+// It records opened documents and returns them by test/documents, and exits by test/crash.
+func TestHelperServer(t *testing.T) {
+	if os.Getenv(helperServerEnv) == "" {
+		t.Skip("run by the supervisor tests")
+	}
+
+	ctx := context.Background()
+	pipe, err := NewIOPipeListener(ctx, os.Stdin, os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	uris := []protocol.DocumentUri{}
+	conn, err := jsonrpc2.Dial(ctx, pipe.Dialer(), NewBinder(jsonrpc2.HandlerFunc(func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+		switch r.Method {
+		case string(protocol.InitializeMethod):
+			return map[string]any{"capabilities": map[string]any{"hoverProvider": true, "textDocumentSync": 1}}, nil
+		case string(protocol.TextDocumentDidOpenMethod):
+			var params protocol.DidOpenTextDocumentParams
+			if err := json.Unmarshal(r.Params, &params); err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			uris = append(uris, params.TextDocument.Uri)
+			mu.Unlock()
+		case "test/documents":
+			mu.Lock()
+			defer mu.Unlock()
+			return uris, nil
+		case "test/crash":
+			os.Exit(1)
+		}
+		if r.IsCall() {
+			return json.RawMessage("null"), nil
+		}
+		return nil, nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Wait()
+	// exit before the test framework writes the result to stdout
+	os.Exit(0)
+}
+
+func TestServerSupervisor(t *testing.T) {
+	backoffBase := restartBackoffBase
+	restartBackoffBase = 10 * time.Millisecond
+	t.Cleanup(func() { restartBackoffBase = backoffBase })
+	t.Setenv(helperServerEnv, "1")
+
+	doc := protocol.TextDocumentItem{Uri: "file:///a.py", LanguageId: "python", Version: 1, Text: "x"}
+
+	tests := []struct {
+		name           string
+		maxRestarts    int
+		crashes        int
+		failedRestores int
+		want           []string
+		wantReady      bool
+	}{
+		{
+			name:        "restart and restore",
+			maxRestarts: 3,
+			crashes:     1,
+			want: []string{
+				"lsmux: helper exited unexpectedly, restarting in 10ms (1/3)",
+				"lsmux: helper restarted",
+			},
+			wantReady: true,
+		},
+		{
+			name:        "restart limit",
+			maxRestarts: 1,
+			crashes:     2,
+			want: []string{
+				"lsmux: helper exited unexpectedly, restarting in 10ms (1/1)",
+				"lsmux: helper restarted",
+				"lsmux: helper exited unexpectedly and reached the restart limit (1)",
+			},
+			wantReady: false,
+		},
+		{
+			name:           "retry failed restore",
+			maxRestarts:    3,
+			crashes:        1,
+			failedRestores: 1,
+			want: []string{
+				"lsmux: helper exited unexpectedly, restarting in 10ms (1/3)",
+				"lsmux: helper exited unexpectedly, restarting in 20ms (2/3)",
+				"lsmux: helper restarted",
+			},
+			wantReady: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			messages := make(chan protocol.ShowMessageParams, 10)
+			clientConn := newTestConnection(t, func(ctx context.Context, r *jsonrpc2.Request) (any, error) {
+				if r.Method == string(protocol.WindowShowMessageMethod) {
+					var params protocol.ShowMessageParams
+					if err := json.Unmarshal(r.Params, &params); err != nil {
+						return nil, err
+					}
+					messages <- params
+				}
+				if r.IsCall() {
+					return json.RawMessage("null"), nil
+				}
+				return nil, nil
+			})
+
+			cfg := ServerConfig{Name: "helper", Command: os.Args[0], Args: []string{"-test.run=^TestHelperServer$"}, MaxRestarts: tt.maxRestarts}
+			server := NewServerConnection(cfg)
+			t.Cleanup(func() { server.Close() })
+			h := newTestClientHandler(&Config{}, server)
+
+			restores := 0
+			restore := func(ctx context.Context, server *ServerConnection) error {
+				restores++
+				// the first restore is the initial start
+				if restores > 1 && restores <= 1+tt.failedRestores {
+					return errors.New("restore failed")
+				}
+				return h.RestoreServer(ctx, server)
+			}
+			serverBinder := NewBinder(jsonrpc2.HandlerFunc(func(context.Context, *jsonrpc2.Request) (any, error) {
+				return nil, jsonrpc2.ErrNotHandled
+			}))
+			supervisor := NewServerSupervisor(cfg, server, serverBinder, clientConn, h.diagRegistry, restore)
+			if err := supervisor.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			testCall(t, h, string(protocol.InitializeMethod), protocol.InitializeParams{})
+			testNotify(t, h, string(protocol.InitializedMethod), protocol.InitializedParams{})
+			testNotify(t, h, string(protocol.TextDocumentDidOpenMethod), protocol.DidOpenTextDocumentParams{TextDocument: doc})
+
+			var got []string
+			for range tt.crashes {
+				if err := server.Notify(ctx, "test/crash", nil); err != nil {
+					t.Fatal(err)
+				}
+				// wait until the supervisor restarts the server or gives up
+				for {
+					select {
+					case msg := <-messages:
+						got = append(got, msg.Message)
+						if msg.Type == protocol.MessageTypeWarning {
+							continue
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatalf("timed out: %v", got)
+					}
+					break
+				}
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("messages mismatch (-want +got):\n%s", diff)
+			}
+			if got := server.Ready(); got != tt.wantReady {
+				t.Errorf("Ready() = %v, want %v", got, tt.wantReady)
+			}
+			if !tt.wantReady {
+				return
+			}
+
+			var uris []protocol.DocumentUri
+			if err := server.Call(ctx, "test/documents", nil, &uris); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff([]protocol.DocumentUri{doc.Uri}, uris); diff != "" {
+				t.Errorf("opened documents mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServerSupervisor_ClearServerState(t *testing.T) {
+	const uri = "file:///a.py"
+	tests := []struct {
+		name        string
+		mode        DiagnosticMode
+		wantPublish int
+		wantRefresh int
+	}{
+		{name: "push mode", mode: DiagnosticModePush, wantPublish: 1, wantRefresh: 0},
+		{name: "pull mode", mode: DiagnosticModePull, wantPublish: 0, wantRefresh: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := NewDiagnosticRegistry(DiagnosticsConfig{Mode: tt.mode}, []string{"ruff"})
+			var published, refreshed int
+			diagnostics.publish = func(context.Context, protocol.PublishDiagnosticsParams) error {
+				published++
+				return nil
+			}
+			diagnostics.refresh = func(context.Context) error {
+				refreshed++
+				return nil
+			}
+			diagnostics.SetRefreshSupport(true)
+			diagnostics.UpdateDiagnostics(uri, "ruff", 1, []protocol.Diagnostic{{Range: rng(0, 0, 0, 1), Message: "ruff1"}})
+
+			cfg := ServerConfig{Name: "ruff"}
+			s := NewServerSupervisor(cfg, NewServerConnection(cfg), nil, nil, diagnostics, nil)
+			s.clearServerState(context.Background())
+
+			if got := diagnostics.GetDiagnostics(uri); got != nil {
+				t.Errorf("GetDiagnostics() = %v, want nil", got)
+			}
+			if published != tt.wantPublish {
+				t.Errorf("publish count = %d, want %d", published, tt.wantPublish)
+			}
+			if refreshed != tt.wantRefresh {
+				t.Errorf("refresh count = %d, want %d", refreshed, tt.wantRefresh)
+			}
+		})
+	}
+}
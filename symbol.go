@@ -0,0 +1,256 @@
+package lsmux
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// DocumentSymbolMerger merges documentSymbol results ([]DocumentSymbol or []SymbolInformation) from multiple servers.
+type DocumentSymbolMerger struct {
+	uri          protocol.DocumentUri
+	hierarchical bool
+	symbols      []protocol.DocumentSymbol
+	infos        []protocol.SymbolInformation
+}
+
+func NewDocumentSymbolMerger(uri protocol.DocumentUri, hierarchical bool) *DocumentSymbolMerger {
+	return &DocumentSymbolMerger{uri: uri, hierarchical: hierarchical}
+}
+
+// Add adds a documentSymbol result.
+func (m *DocumentSymbolMerger) Add(res json.RawMessage) error {
+	if isEmptyResult(res) {
+		return nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(res, &items); err != nil {
+		return err
+	}
+
+	var infos []protocol.SymbolInformation
+	for _, item := range items {
+		var probe struct {
+			Location json.RawMessage `json:"location"`
+		}
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return err
+		}
+
+		if probe.Location != nil {
+			var info protocol.SymbolInformation
+			if err := json.Unmarshal(item, &info); err != nil {
+				return err
+			}
+			infos = append(infos, info)
+		} else {
+			var symbol protocol.DocumentSymbol
+			if err := json.Unmarshal(item, &symbol); err != nil {
+				return err
+			}
+			m.addDocumentSymbol(symbol, "")
+		}
+	}
+	m.addSymbolInformations(infos)
+	return nil
+}
+
+// addSymbolInformations adds symbols of a result. In hierarchical mode, symbols are nested into their containers in the result.
+func (m *DocumentSymbolMerger) addSymbolInformations(infos []protocol.SymbolInformation) {
+	if !m.hierarchical {
+		m.infos = append(m.infos, infos...)
+		return
+	}
+
+	type node struct {
+		symbol   protocol.DocumentSymbol
+		children []*node
+	}
+	// containers precede their members
+	infos = slices.Clone(infos)
+	slices.SortStableFunc(infos, func(a, b protocol.SymbolInformation) int {
+		return cmp.Or(comparePosition(a.Location.Range.Start, b.Location.Range.Start), comparePosition(b.Location.Range.End, a.Location.Range.End))
+	})
+
+	var roots, nodes []*node
+	for _, info := range infos {
+		n := &node{symbol: protocol.DocumentSymbol{
+			Name:           info.Name,
+			Kind:           info.Kind,
+			Tags:           info.Tags,
+			Deprecated:     info.Deprecated,
+			Range:          info.Location.Range,
+			SelectionRange: info.Location.Range,
+		}}
+
+		var parent *node
+		if info.ContainerName != "" {
+			// the innermost container enclosing the symbol is the last one
+			for _, c := range slices.Backward(nodes) {
+				if c.symbol.Name == info.ContainerName && containsRange(c.symbol.Range, info.Location.Range) {
+					parent = c
+					break
+				}
+			}
+		}
+		if parent != nil {
+			parent.children = append(parent.children, n)
+		} else {
+			roots = append(roots, n)
+		}
+		nodes = append(nodes, n)
+	}
+
+	var build func(nodes []*node) []protocol.DocumentSymbol
+	build = func(nodes []*node) []protocol.DocumentSymbol {
+		var symbols []protocol.DocumentSymbol
+		for _, n := range nodes {
+			if len(n.children) != 0 {
+				n.symbol.Children = build(n.children)
+			}
+			symbols = append(symbols, n.symbol)
+		}
+		return symbols
+	}
+	m.symbols = append(m.symbols, build(roots)...)
+}
+
+// containsRange reports whether outer contains inner.
+func containsRange(outer, inner protocol.Range) bool {
+	return comparePosition(outer.Start, inner.Start) <= 0 && comparePosition(inner.End, outer.End) <= 0
+}
+
+func (m *DocumentSymbolMerger) addDocumentSymbol(symbol protocol.DocumentSymbol, containerName string) {
+	if m.hierarchical {
+		m.symbols = append(m.symbols, symbol)
+		return
+	}
+
+	m.infos = append(m.infos, protocol.SymbolInformation{
+		Name:          symbol.Name,
+		Kind:          symbol.Kind,
+		Tags:          symbol.Tags,
+		Deprecated:    symbol.Deprecated,
+		ContainerName: containerName,
+		Location:      protocol.Location{Uri: m.uri, Range: symbol.Range},
+	})
+	for _, child := range symbol.Children {
+		m.addDocumentSymbol(child, symbol.Name)
+	}
+}
+
+// Result returns []DocumentSymbol if hierarchical, []SymbolInformation otherwise.
+func (m *DocumentSymbolMerger) Result() any {
+	if m.hierarchical {
+		return dedupDocumentSymbols(m.symbols)
+	}
+	return dedupSymbolInformations(m.infos)
+}
+
+type symbolKey struct {
+	name string
+	kind protocol.SymbolKind
+	rng  protocol.Range
+}
+
+// dedupDocumentSymbols drops duplicate symbols, merging their children, and sorts symbols by position.
+func dedupDocumentSymbols(symbols []protocol.DocumentSymbol) []protocol.DocumentSymbol {
+	seen := map[symbolKey]int{}
+	res := []protocol.DocumentSymbol{}
+	for _, symbol := range symbols {
+		key := symbolKey{symbol.Name, symbol.Kind, symbol.Range}
+		if i, ok := seen[key]; ok {
+			res[i].Children = append(res[i].Children, symbol.Children...)
+			continue
+		}
+		seen[key] = len(res)
+		symbol.Children = slices.Clone(symbol.Children)
+		res = append(res, symbol)
+	}
+
+	for i := range res {
+		if len(res[i].Children) != 0 {
+			res[i].Children = dedupDocumentSymbols(res[i].Children)
+		}
+	}
+	slices.SortStableFunc(res, func(a, b protocol.DocumentSymbol) int {
+		return comparePosition(a.Range.Start, b.Range.Start)
+	})
+	return res
+}
+
+func dedupSymbolInformations(infos []protocol.SymbolInformation) []protocol.SymbolInformation {
+	seen := map[symbolKey]struct{}{}
+	res := []protocol.SymbolInformation{}
+	for _, info := range infos {
+		key := symbolKey{info.Name, info.Kind, info.Location.Range}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		res = append(res, info)
+	}
+	slices.SortStableFunc(res, func(a, b protocol.SymbolInformation) int {
+		return comparePosition(a.Location.Range.Start, b.Location.Range.Start)
+	})
+	return res
+}
+
+// workspaceSymbol is a common form of SymbolInformation and WorkspaceSymbol.
+type workspaceSymbol struct {
+	Name          string               `json:"name"`
+	Kind          protocol.SymbolKind  `json:"kind"`
+	Tags          []protocol.SymbolTag `json:"tags,omitempty"`
+	ContainerName string               `json:"containerName,omitempty"`
+	Deprecated    bool                 `json:"deprecated,omitempty"`
+	Location      struct {
+		Uri   protocol.DocumentUri `json:"uri"`
+		Range *protocol.Range      `json:"range,omitempty"`
+	} `json:"location"`
+	Data any `json:"data,omitempty"`
+}
+
+// WorkspaceSymbolMerger merges workspace/symbol results ([]SymbolInformation or []WorkspaceSymbol) from multiple servers.
+type WorkspaceSymbolMerger struct {
+	maxResultsPerServer int
+	symbols             []workspaceSymbol
+}
+
+func NewWorkspaceSymbolMerger(maxResultsPerServer int) *WorkspaceSymbolMerger {
+	return &WorkspaceSymbolMerger{maxResultsPerServer: maxResultsPerServer}
+}
+
+// Add adds a workspace/symbol result of the server.
+func (m *WorkspaceSymbolMerger) Add(serverName string, res json.RawMessage) error {
+	if isEmptyResult(res) {
+		return nil
+	}
+
+	var symbols []workspaceSymbol
+	if err := json.Unmarshal(res, &symbols); err != nil {
+		return err
+	}
+	if m.maxResultsPerServer > 0 && len(symbols) > m.maxResultsPerServer {
+		symbols = symbols[:m.maxResultsPerServer]
+	}
+
+	for _, symbol := range symbols {
+		// add server name to workspace symbol data for future resolve
+		if symbol.Data != nil || symbol.Location.Range == nil {
+			symbol.Data = wrapServerData(serverName, symbol.Data)
+		}
+		m.symbols = append(m.symbols, symbol)
+	}
+	return nil
+}
+
+// Result returns merged symbols. Each symbol is valid as both SymbolInformation and WorkspaceSymbol.
+func (m *WorkspaceSymbolMerger) Result() any {
+	if m.symbols == nil {
+		return []workspaceSymbol{}
+	}
+	return m.symbols
+}
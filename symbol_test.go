@@ -0,0 +1,158 @@
+package lsmux
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestDocumentSymbolMerger(t *testing.T) {
+	results := []string{
+		`[{"name": "Foo", "kind": 5, "range": {"start": {"line": 0, "character": 0}, "end": {"line": 3, "character": 1}}, "selectionRange": {"start": {"line": 0, "character": 6}, "end": {"line": 0, "character": 9}},
+		   "children": [{"name": "bar", "kind": 6, "range": {"start": {"line": 1, "character": 2}, "end": {"line": 2, "character": 3}}, "selectionRange": {"start": {"line": 1, "character": 2}, "end": {"line": 1, "character": 5}}}]}]`,
+		`null`,
+		`[{"name": "Foo", "kind": 5, "location": {"uri": "file:///a.vue", "range": {"start": {"line": 0, "character": 0}, "end": {"line": 3, "character": 1}}}},
+		  {"name": "template", "kind": 2, "location": {"uri": "file:///a.vue", "range": {"start": {"line": 5, "character": 0}, "end": {"line": 8, "character": 11}}}}]`,
+	}
+
+	t.Run("hierarchical", func(t *testing.T) {
+		m := NewDocumentSymbolMerger("file:///a.vue", true)
+		for _, res := range results {
+			if err := m.Add(json.RawMessage(res)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		want := []protocol.DocumentSymbol{
+			{Name: "Foo", Kind: protocol.SymbolKindClass, Range: rng(0, 0, 3, 1), SelectionRange: rng(0, 6, 0, 9), Children: []protocol.DocumentSymbol{
+				{Name: "bar", Kind: protocol.SymbolKindMethod, Range: rng(1, 2, 2, 3), SelectionRange: rng(1, 2, 1, 5)},
+			}},
+			{Name: "template", Kind: protocol.SymbolKindModule, Range: rng(5, 0, 8, 11), SelectionRange: rng(5, 0, 8, 11)},
+		}
+		if diff := cmp.Diff(want, m.Result()); diff != "" {
+			t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		m := NewDocumentSymbolMerger("file:///a.vue", false)
+		for _, res := range results {
+			if err := m.Add(json.RawMessage(res)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		want := []protocol.SymbolInformation{
+			{Name: "Foo", Kind: protocol.SymbolKindClass, Location: protocol.Location{Uri: "file:///a.vue", Range: rng(0, 0, 3, 1)}},
+			{Name: "bar", Kind: protocol.SymbolKindMethod, ContainerName: "Foo", Location: protocol.Location{Uri: "file:///a.vue", Range: rng(1, 2, 2, 3)}},
+			{Name: "template", Kind: protocol.SymbolKindModule, Location: protocol.Location{Uri: "file:///a.vue", Range: rng(5, 0, 8, 11)}},
+		}
+		if diff := cmp.Diff(want, m.Result()); diff != "" {
+			t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestDocumentSymbolMerger_Hierarchical(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []string
+		want    []protocol.DocumentSymbol
+	}{
+		{
+			name: "container names",
+			results: []string{
+				`[{"name": "bar", "kind": 6, "containerName": "Foo", "location": {"uri": "file:///a.py", "range": {"start": {"line": 1, "character": 2}, "end": {"line": 2, "character": 3}}}},
+				  {"name": "Foo", "kind": 5, "location": {"uri": "file:///a.py", "range": {"start": {"line": 0, "character": 0}, "end": {"line": 3, "character": 1}}}},
+				  {"name": "baz", "kind": 12, "containerName": "Foo", "location": {"uri": "file:///a.py", "range": {"start": {"line": 5, "character": 0}, "end": {"line": 6, "character": 1}}}}]`,
+			},
+			want: []protocol.DocumentSymbol{
+				{Name: "Foo", Kind: protocol.SymbolKindClass, Range: rng(0, 0, 3, 1), SelectionRange: rng(0, 0, 3, 1), Children: []protocol.DocumentSymbol{
+					{Name: "bar", Kind: protocol.SymbolKindMethod, Range: rng(1, 2, 2, 3), SelectionRange: rng(1, 2, 2, 3)},
+				}},
+				{Name: "baz", Kind: protocol.SymbolKindFunction, Range: rng(5, 0, 6, 1), SelectionRange: rng(5, 0, 6, 1)},
+			},
+		},
+		{
+			name: "children of duplicate parents",
+			results: []string{
+				`[{"name": "Foo", "kind": 5, "range": {"start": {"line": 5, "character": 0}, "end": {"line": 9, "character": 1}}, "selectionRange": {"start": {"line": 5, "character": 6}, "end": {"line": 5, "character": 9}},
+				   "children": [{"name": "a", "kind": 6, "range": {"start": {"line": 7, "character": 2}, "end": {"line": 7, "character": 9}}, "selectionRange": {"start": {"line": 7, "character": 2}, "end": {"line": 7, "character": 3}}}]}]`,
+				`[{"name": "Foo", "kind": 5, "range": {"start": {"line": 5, "character": 0}, "end": {"line": 9, "character": 1}}, "selectionRange": {"start": {"line": 5, "character": 6}, "end": {"line": 5, "character": 9}},
+				   "children": [{"name": "b", "kind": 6, "range": {"start": {"line": 6, "character": 2}, "end": {"line": 6, "character": 9}}, "selectionRange": {"start": {"line": 6, "character": 2}, "end": {"line": 6, "character": 3}}}]},
+				  {"name": "Bar", "kind": 5, "range": {"start": {"line": 0, "character": 0}, "end": {"line": 2, "character": 1}}, "selectionRange": {"start": {"line": 0, "character": 6}, "end": {"line": 0, "character": 9}}}]`,
+			},
+			want: []protocol.DocumentSymbol{
+				{Name: "Bar", Kind: protocol.SymbolKindClass, Range: rng(0, 0, 2, 1), SelectionRange: rng(0, 6, 0, 9)},
+				{Name: "Foo", Kind: protocol.SymbolKindClass, Range: rng(5, 0, 9, 1), SelectionRange: rng(5, 6, 5, 9), Children: []protocol.DocumentSymbol{
+					{Name: "b", Kind: protocol.SymbolKindMethod, Range: rng(6, 2, 6, 9), SelectionRange: rng(6, 2, 6, 3)},
+					{Name: "a", Kind: protocol.SymbolKindMethod, Range: rng(7, 2, 7, 9), SelectionRange: rng(7, 2, 7, 3)},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewDocumentSymbolMerger("file:///a.py", true)
+			for _, res := range tt.results {
+				if err := m.Add(json.RawMessage(res)); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if diff := cmp.Diff(tt.want, m.Result()); diff != "" {
+				t.Errorf("Result() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWorkspaceSymbolMerger(t *testing.T) {
+	m := NewWorkspaceSymbolMerger(2)
+	results := []struct {
+		server string
+		res    string
+	}{
+		{"pyright", `[
+			{"name": "a", "kind": 12, "location": {"uri": "file:///a.py", "range": {"start": {"line": 1, "character": 0}, "end": {"line": 1, "character": 1}}}},
+			{"name": "b", "kind": 12, "location": {"uri": "file:///b.py", "range": {"start": {"line": 1, "character": 0}, "end": {"line": 1, "character": 1}}}},
+			{"name": "c", "kind": 12, "location": {"uri": "file:///c.py", "range": {"start": {"line": 1, "character": 0}, "end": {"line": 1, "character": 1}}}}
+		]`},
+		{"ruff", `null`},
+		{"other", `[{"name": "d", "kind": 12, "location": {"uri": "file:///d.py"}, "data": 1}]`},
+	}
+	for _, r := range results {
+		if err := m.Add(r.server, json.RawMessage(r.res)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	b, err := json.Marshal(m.Result())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []any
+	for _, symbol := range got {
+		names = append(names, symbol["name"])
+	}
+	if diff := cmp.Diff([]any{"a", "b", "d"}, names); diff != "" {
+		t.Errorf("symbol names mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := got[0]["data"]; ok {
+		t.Errorf("data should not be added to resolved symbol: %v", got[0])
+	}
+	serverName, data, err := unwrapServerData(got[2]["data"])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if serverName != "other" || data != float64(1) {
+		t.Errorf("unwrapServerData() = %v, %v, want other, 1", serverName, data)
+	}
+}
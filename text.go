@@ -0,0 +1,262 @@
+package lsmux
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+// positionToOffset returns the byte offset of the position in text.
+// The position is clamped to the end of the line or text.
+func positionToOffset(text string, pos protocol.Position, enc protocol.PositionEncodingKind) int {
+	offset := 0
+	for range pos.Line {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i == -1 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+
+	for units := uint32(0); units < pos.Character && offset < len(text); {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		if r == '\n' || r == '\r' {
+			break
+		}
+		units += runeUnits(r, size, enc)
+		offset += size
+	}
+	return offset
+}
+
+// offsetToPosition returns the position of the byte offset in text.
+func offsetToPosition(text string, offset int, enc protocol.PositionEncodingKind) protocol.Position {
+	offset = min(offset, len(text))
+
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	pos := protocol.Position{Line: uint32(strings.Count(text[:lineStart], "\n"))}
+	for _, r := range text[lineStart:offset] {
+		pos.Character += runeUnits(r, utf8.RuneLen(r), enc)
+	}
+	return pos
+}
+
+func runeUnits(r rune, size int, enc protocol.PositionEncodingKind) uint32 {
+	switch enc {
+	case protocol.PositionEncodingKindUTF8:
+		return uint32(size)
+	case protocol.PositionEncodingKindUTF32:
+		return 1
+	default:
+		if r >= 0x10000 {
+			return 2
+		}
+		return 1
+	}
+}
+
+// applyTextEdits applies non-overlapping edits to text.
+func applyTextEdits(text string, edits []protocol.TextEdit, enc protocol.PositionEncodingKind) (string, error) {
+	type edit struct {
+		start, end int
+		newText    string
+	}
+
+	offsetEdits := make([]edit, len(edits))
+	for i, e := range edits {
+		offsetEdits[i] = edit{
+			start:   positionToOffset(text, e.Range.Start, enc),
+			end:     positionToOffset(text, e.Range.End, enc),
+			newText: e.NewText,
+		}
+	}
+	// edits at the same position are applied in the array order
+	slices.SortStableFunc(offsetEdits, func(a, b edit) int { return cmp.Compare(a.start, b.start) })
+
+	var sb strings.Builder
+	last := 0
+	for _, e := range offsetEdits {
+		if e.start < last || e.end < e.start {
+			return "", fmt.Errorf("overlapping text edits")
+		}
+		sb.WriteString(text[last:e.start])
+		sb.WriteString(e.newText)
+		last = e.end
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), nil
+}
+
+// diffTextEdits returns line based edits that change oldText to newText.
+func diffTextEdits(oldText, newText string, enc protocol.PositionEncodingKind) []protocol.TextEdit {
+	oldLines := splitLines(oldText)
+	newLines := splitLines(newText)
+
+	// hunks start and end at the beginning of lines, or at the end of text
+	linePosition := func(i int) protocol.Position {
+		if i < len(oldLines) {
+			return protocol.Position{Line: uint32(i)}
+		}
+		return offsetToPosition(oldText, len(oldText), enc)
+	}
+
+	edits := []protocol.TextEdit{}
+	for _, h := range diffLines(oldLines, newLines) {
+		edits = append(edits, protocol.TextEdit{
+			Range: protocol.Range{
+				Start: linePosition(h.oldStart),
+				End:   linePosition(h.oldEnd),
+			},
+			NewText: strings.Join(newLines[h.newStart:h.newEnd], ""),
+		})
+	}
+	return edits
+}
+
+// splitLines splits text into lines, keeping line terminators.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffHunk means that a[oldStart:oldEnd] is replaced with b[newStart:newEnd].
+type diffHunk struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// maxDiffEditDistance limits the edit distance searched by diffLines, since the search takes O((n+m)D) time and O(D^2) space.
+var maxDiffEditDistance = 1000
+
+// diffLines returns the shortest edit script from a to b as hunks using the Myers algorithm.
+// If the edit distance exceeds maxDiffEditDistance, the lines between the common prefix and suffix are replaced by a single hunk.
+func diffLines(a, b []string) []diffHunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+
+	hunks, ok := myersDiff(a, b, maxDiffEditDistance)
+	if !ok {
+		hunks = []diffHunk{{oldEnd: len(a), newEnd: len(b)}}
+	}
+	for i := range hunks {
+		hunks[i].oldStart += prefix
+		hunks[i].oldEnd += prefix
+		hunks[i].newStart += prefix
+		hunks[i].newEnd += prefix
+	}
+	return hunks
+}
+
+// myersDiff returns the shortest edit script from a to b as hunks.
+// It returns false if the edit distance exceeds maxD.
+func myersDiff(a, b []string, maxD int) ([]diffHunk, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] keeps v[-(d-1)..d-1] before the step d, which is all the backtracking reads
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxD {
+			return nil, false
+		}
+		trace = append(trace, slices.Clone(v[offset-max(d-1, 0):offset+d]))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// backtrack the trace to collect the edit script in reverse order
+	type op int
+	const (
+		opEqual op = iota
+		opDelete
+		opInsert
+	)
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := func(k int) int { return trace[d][k+d-1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev(k-1) < prev(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev(prevK)
+		prevY := prevX - prevK
+
+		// a single deletion or insertion followed by equal lines
+		moveOp, midX := opDelete, prevX+1
+		if prevK == k+1 {
+			moveOp, midX = opInsert, prevX
+		}
+		for ; x > midX; x-- {
+			ops = append(ops, opEqual)
+		}
+		ops = append(ops, moveOp)
+		x, y = prevX, prevY
+	}
+	for range x {
+		ops = append(ops, opEqual)
+	}
+	slices.Reverse(ops)
+
+	// merge adjacent deletions and insertions into hunks
+	var hunks []diffHunk
+	i, j := 0, 0
+	for idx := 0; idx < len(ops); {
+		if ops[idx] == opEqual {
+			i++
+			j++
+			idx++
+			continue
+		}
+
+		h := diffHunk{oldStart: i, newStart: j}
+		for ; idx < len(ops) && ops[idx] != opEqual; idx++ {
+			if ops[idx] == opDelete {
+				i++
+			} else {
+				j++
+			}
+		}
+		h.oldEnd, h.newEnd = i, j
+		hunks = append(hunks, h)
+	}
+	return hunks, true
+}
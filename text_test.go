@@ -0,0 +1,159 @@
+package lsmux
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
+func TestPositionToOffset(t *testing.T) {
+	text := "a😀b\ncd\n"
+
+	tests := []struct {
+		name string
+		pos  protocol.Position
+		enc  protocol.PositionEncodingKind
+		want int
+	}{
+		{name: "utf-16", pos: protocol.Position{Line: 0, Character: 3}, enc: protocol.PositionEncodingKindUTF16, want: 5},
+		{name: "utf-8", pos: protocol.Position{Line: 0, Character: 5}, enc: protocol.PositionEncodingKindUTF8, want: 5},
+		{name: "utf-32", pos: protocol.Position{Line: 0, Character: 2}, enc: protocol.PositionEncodingKindUTF32, want: 5},
+		{name: "second line", pos: protocol.Position{Line: 1, Character: 1}, enc: protocol.PositionEncodingKindUTF16, want: 8},
+		{name: "clamp to line end", pos: protocol.Position{Line: 1, Character: 10}, enc: protocol.PositionEncodingKindUTF16, want: 9},
+		{name: "clamp to text end", pos: protocol.Position{Line: 5, Character: 0}, enc: protocol.PositionEncodingKindUTF16, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := positionToOffset(text, tt.pos, tt.enc)
+			if got != tt.want {
+				t.Errorf("positionToOffset() = %v, want %v", got, tt.want)
+			}
+			if tt.name != "clamp to line end" && tt.name != "clamp to text end" {
+				if pos := offsetToPosition(text, got, tt.enc); pos != tt.pos {
+					t.Errorf("offsetToPosition() = %v, want %v", pos, tt.pos)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	text := "import os\nimport sys\n\nprint( 1 )\n"
+	edits := []protocol.TextEdit{
+		{Range: rng(3, 6, 3, 7), NewText: ""},
+		{Range: rng(3, 8, 3, 9), NewText: ""},
+		{Range: rng(0, 0, 0, 0), NewText: "# header\n"},
+		{Range: rng(0, 0, 0, 0), NewText: "\n"},
+	}
+	want := "# header\n\nimport os\nimport sys\n\nprint(1)\n"
+
+	got, err := applyTextEdits(text, edits, protocol.PositionEncodingKindUTF16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("applyTextEdits() = %q, want %q", got, want)
+	}
+
+	if _, err := applyTextEdits(text, []protocol.TextEdit{
+		{Range: rng(0, 0, 1, 0), NewText: ""},
+		{Range: rng(0, 5, 0, 6), NewText: ""},
+	}, protocol.PositionEncodingKindUTF16); err == nil {
+		t.Errorf("expected error for overlapping edits, got nil")
+	}
+}
+
+func TestDiffTextEdits(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    []protocol.TextEdit
+	}{
+		{
+			name:    "same",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			want:    []protocol.TextEdit{},
+		},
+		{
+			name:    "replace lines",
+			oldText: "a\nb\nc\nd\ne\n",
+			newText: "a\nB\nc\nd\nE\nF\n",
+			want: []protocol.TextEdit{
+				{Range: rng(1, 0, 2, 0), NewText: "B\n"},
+				{Range: rng(4, 0, 5, 0), NewText: "E\nF\n"},
+			},
+		},
+		{
+			name:    "insert and delete",
+			oldText: "a\nb\nc\n",
+			newText: "x\na\nc\n",
+			want: []protocol.TextEdit{
+				{Range: rng(0, 0, 0, 0), NewText: "x\n"},
+				{Range: rng(1, 0, 2, 0), NewText: ""},
+			},
+		},
+		{
+			name:    "last line without newline",
+			oldText: "a\nb",
+			newText: "a\nb\n",
+			want: []protocol.TextEdit{
+				{Range: rng(1, 0, 1, 1), NewText: "b\n"},
+			},
+		},
+		{
+			name:    "from empty",
+			oldText: "",
+			newText: "a\n",
+			want: []protocol.TextEdit{
+				{Range: rng(0, 0, 0, 0), NewText: "a\n"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffTextEdits(tt.oldText, tt.newText, protocol.PositionEncodingKindUTF16)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("diffTextEdits() mismatch (-want +got):\n%s", diff)
+			}
+
+			applied, err := applyTextEdits(tt.oldText, got, protocol.PositionEncodingKindUTF16)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if applied != tt.newText {
+				t.Errorf("applied text = %q, want %q", applied, tt.newText)
+			}
+		})
+	}
+}
+
+func TestDiffTextEdits_Large(t *testing.T) {
+	var oldLines, newLines []string
+	for i := range 20000 {
+		oldLines = append(oldLines, fmt.Sprintf("old %d\n", i))
+		newLines = append(newLines, fmt.Sprintf("new %d\n", i))
+	}
+	oldText := "head\n" + strings.Join(oldLines, "") + "tail\n"
+	newText := "head\n" + strings.Join(newLines, "") + "tail\n"
+
+	got := diffTextEdits(oldText, newText, protocol.PositionEncodingKindUTF16)
+	want := []protocol.TextEdit{
+		{Range: rng(1, 0, 20001, 0), NewText: strings.Join(newLines, "")},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diffTextEdits() mismatch (-want +got):\n%s", diff)
+	}
+
+	applied, err := applyTextEdits(oldText, got, protocol.PositionEncodingKindUTF16)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if applied != newText {
+		t.Errorf("applied text mismatch")
+	}
+}
@@ -1,5 +1,12 @@
 package lsmux
 
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/myleshyson/lsprotocol-go/protocol"
+)
+
 // SliceFor creates a slice of type T with length n.
 func SliceFor[T any](t T, n int) []T {
 	return make([]T, n)
@@ -12,3 +19,16 @@ func Deref[T any](t *T) T {
 	}
 	return *t
 }
+
+// textDocumentURI returns textDocument.uri of the request params, or empty if params does not have it.
+func textDocumentURI(params json.RawMessage) protocol.DocumentUri {
+	var v struct {
+		TextDocument struct {
+			Uri protocol.DocumentUri `json:"uri"`
+		} `json:"textDocument"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(params), &v); err != nil {
+		return ""
+	}
+	return v.TextDocument.Uri
+}